@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"math/rand"
+	"time"
 
 	nn "github.com/eissana/gograd/neural-network"
 	"gonum.org/v1/plot/vg"
@@ -21,7 +23,8 @@ func main() {
 		nn.MakeLayerParam(1, nn.Sigmoid),
 	}
 	// Creates a neural network with input size of 2.
-	model := nn.MakeNeuralNetwork( /*inputSize=*/ 2, layerParams)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	model := nn.MakeNeuralNetwork( /*inputSize=*/ 2, layerParams, rng)
 
 	trainingParam := nn.TrainingParam{
 		Epochs:                  epochs,
@@ -44,7 +47,7 @@ func main() {
 	plotter.ScatterPlot(inputX, inputY, "data/make_moon.png")
 
 	// Trains the model and returns losses and scores.
-	losses, scores := model.Train(inputs, labels, trainingParam)
+	losses, scores, _ := model.Train(inputs, labels, trainingParam)
 
 	// Computes the accuracy of the model.
 	accuracy := nn.Accuracy(scores, labels, trainingParam)
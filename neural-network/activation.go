@@ -2,6 +2,7 @@ package nn
 
 import (
 	"math"
+	"reflect"
 )
 
 // Given a function f and its derivative g, returns an activation function.
@@ -13,8 +14,8 @@ func MakeActivation(op string, f, g func(float64) float64) func(*Value) *Value {
 			op:       op,
 			children: []*Value{value},
 		}
-		ans.backward = func() {
-			value.grad += g(value.data) * ans.grad
+		ans.backward = func(grad float64, accumulate accumulator) {
+			accumulate(value, g(value.data)*grad)
 		}
 		return ans
 	}
@@ -37,39 +38,305 @@ func Relu(value *Value) *Value {
 	return MakeActivation("ReLU", f, g)(value)
 }
 
+// Leaky rectified linear unit: y = x if x > 0 else alpha*x
+func LeakyRelu(alpha float64) func(*Value) *Value {
+	f := func(x float64) float64 {
+		if x > 0.0 {
+			return x
+		}
+		return alpha * x
+	}
+	g := func(x float64) float64 {
+		if x > 0.0 {
+			return 1.0
+		}
+		return alpha
+	}
+	activation := MakeActivation("LeakyReLU", f, g)
+	// A closure of LeakyRelu, so activationName can tell it apart.
+	return func(value *Value) *Value { return activation(value) }
+}
+
+// Exponential linear unit: y = x if x > 0 else alpha*(exp(x) - 1)
+func Elu(alpha float64) func(*Value) *Value {
+	f, g := elu(1.0, alpha)
+	activation := MakeActivation("ELU", f, g)
+	// A closure of Elu, so activationName can tell it apart.
+	return func(value *Value) *Value { return activation(value) }
+}
+
+// Scaled exponential linear unit: y = scale * Elu(alpha)(x) with the constants
+// making the activations self-normalizing.
+func Selu(value *Value) *Value {
+	const (
+		alpha = 1.6732632423543772
+		scale = 1.0507009873554805
+	)
+	f, g := elu(scale, alpha)
+	return MakeActivation("SELU", f, g)(value)
+}
+
+// Returns scale*elu(x) and its derivative. Expm1 keeps precision for small x.
+func elu(scale, alpha float64) (func(float64) float64, func(float64) float64) {
+	f := func(x float64) float64 {
+		if x > 0.0 {
+			return scale * x
+		}
+		return scale * alpha * math.Expm1(x)
+	}
+	g := func(x float64) float64 {
+		if x > 0.0 {
+			return scale
+		}
+		return scale * alpha * math.Exp(x)
+	}
+	return f, g
+}
+
+// Gaussian error linear unit: y = x * Phi(x) where Phi is the cumulative
+// distribution function of the standard normal distribution.
+func Gelu(value *Value) *Value {
+	f := func(x float64) float64 {
+		return x * normalCDF(x)
+	}
+	g := func(x float64) float64 {
+		return normalCDF(x) + x*math.Exp(-0.5*x*x)/math.Sqrt(2*math.Pi)
+	}
+	return MakeActivation("GELU", f, g)(value)
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
 // Sigmoid function: y = 1/(1 + exp(-x))
 func Sigmoid(value *Value) *Value {
-	f := func(x float64) float64 {
+	g := func(x float64) float64 {
+		y := sigmoid(x)
+		return y * (1 - y)
+	}
+	return MakeActivation("Sigmoid", sigmoid, g)(value)
+}
+
+// Computes 1/(1 + exp(-x)) without overflowing exp for large |x|.
+func sigmoid(x float64) float64 {
+	if x >= 0.0 {
 		return 1.0 / (1.0 + math.Exp(-x))
 	}
+	y := math.Exp(x)
+	return y / (1.0 + y)
+}
+
+// Sigmoid linear unit (AKA swish): y = x * sigmoid(x)
+func Silu(value *Value) *Value {
+	f := func(x float64) float64 {
+		return x * sigmoid(x)
+	}
 	g := func(x float64) float64 {
-		y := f(x)
-		return y * (1 - y)
+		y := sigmoid(x)
+		return y * (1.0 + x*(1.0-y))
 	}
-	return MakeActivation("Sigmoid", f, g)(value)
+	return MakeActivation("SiLU", f, g)(value)
 }
 
-// Hyperbolic tangent (tanh): y = (exp(2x) - 1) / (exp(2x) + 1)
-func Tanh(value *Value) *Value {
+// Softplus function: y = log(1 + exp(x))
+func Softplus(value *Value) *Value {
+	return MakeActivation("Softplus", softplus, sigmoid)(value)
+}
+
+// Computes log(1 + exp(x)) = max(x, 0) + log(1 + exp(-|x|)) without
+// overflowing exp for large x.
+func softplus(x float64) float64 {
+	return math.Max(x, 0.0) + math.Log1p(math.Exp(-math.Abs(x)))
+}
+
+// Mish function: y = x * tanh(softplus(x))
+func Mish(value *Value) *Value {
 	f := func(x float64) float64 {
-		y := math.Exp(2 * x)
-		return (y - 1.0) / (y + 1.0)
+		return x * math.Tanh(softplus(x))
+	}
+	g := func(x float64) float64 {
+		y := math.Tanh(softplus(x))
+		return y + x*(1.0-y*y)*sigmoid(x)
 	}
+	return MakeActivation("Mish", f, g)(value)
+}
+
+// Hyperbolic tangent (tanh): y = (exp(2x) - 1) / (exp(2x) + 1), computed by
+// math.Tanh which does not overflow for large |x|.
+func Tanh(value *Value) *Value {
 	g := func(x float64) float64 {
-		y := f(x)
+		y := math.Tanh(x)
 		return 1.0 - y*y
 	}
-	return MakeActivation("Tanh", f, g)(value)
+	return MakeActivation("Tanh", math.Tanh, g)(value)
 }
 
-// Exponent: y = exp(x)
-// The output needs normalization which will be done in the specified layer.
-func Softmax(value *Value) *Value {
+// Hard hyperbolic tangent: y = max(-1, min(1, x))
+func HardTanh(value *Value) *Value {
 	f := func(x float64) float64 {
-		return math.Exp(x)
+		return math.Max(-1.0, math.Min(1.0, x))
 	}
 	g := func(x float64) float64 {
-		return f(x)
+		if x > -1.0 && x < 1.0 {
+			return 1.0
+		}
+		return 0.0
+	}
+	return MakeActivation("HardTanh", f, g)(value)
+}
+
+// Exponent: y = exp(x)
+func Exp(value *Value) *Value {
+	return MakeActivation("Exp", math.Exp, math.Exp)(value)
+}
+
+// Names of the activations of this package by the code of their functions.
+// Closures made by the same function, e.g. LeakyRelu(alpha), share their
+// code, so they are named after it.
+var activationNames = map[uintptr]string{
+	codeOf(Relu):           "ReLU",
+	codeOf(LeakyRelu(0.0)): "LeakyReLU",
+	codeOf(Elu(1.0)):       "ELU",
+	codeOf(Selu):           "SELU",
+	codeOf(Gelu):           "GELU",
+	codeOf(Sigmoid):        "Sigmoid",
+	codeOf(Silu):           "SiLU",
+	codeOf(Softplus):       "Softplus",
+	codeOf(Mish):           "Mish",
+	codeOf(Tanh):           "Tanh",
+	codeOf(HardTanh):       "HardTanh",
+	codeOf(Exp):            "Exp",
+	codeOf(Softmax):        "Softmax",
+	codeOf(PRelu(0.0)):     "PReLU",
+	codeOf(Swish(1.0)):     "Swish",
+}
+
+func codeOf(f interface{}) uintptr {
+	return reflect.ValueOf(f).Pointer()
+}
+
+// Returns the name of an activation function of this package, or "" for
+// other functions and nil.
+func activationName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if !v.IsValid() || v.IsNil() {
+		return ""
+	}
+	return activationNames[v.Pointer()]
+}
+
+// Applies an activation function to each value separately.
+func elementwise(activation func(*Value) *Value) func([]*Value) []*Value {
+	return func(input []*Value) []*Value {
+		ans := make([]*Value, len(input))
+		for i, value := range input {
+			ans[i] = activation(value)
+		}
+		return ans
+	}
+}
+
+// Softmax function: y_i = exp(x_i) / (exp(x_1) + ... + exp(x_n))
+// The maximum of x is subtracted first so exp does not overflow, which does
+// not change the output.
+func Softmax(input []*Value) []*Value {
+	max := math.Inf(-1)
+	for _, x := range input {
+		max = math.Max(max, x.data)
+	}
+	shift := MakeValue(max)
+	ans := make([]*Value, len(input))
+	sum := MakeValue(0.0)
+	for i, x := range input {
+		ans[i] = x.Sub(shift).Exp()
+		sum = sum.Add(ans[i])
+	}
+	for i := range ans {
+		ans[i] = ans[i].Div(sum)
+	}
+	return ans
+}
+
+// An activation function of all outputs of a layer which owns trainable
+// parameters. The parameters are trained together with the layer.
+type LearnableActivation interface {
+	// Computes the activations of all outputs of a layer.
+	Fit(input []*Value) []*Value
+	// Returns the trainable parameters of the activation.
+	Parameters() []*Value
+}
+
+// Parametric rectified linear unit: y_i = x_i if x_i > 0 else a_i*x_i where
+// the slope a_i of each neuron is trainable and initialized to alpha.
+func PRelu(alpha float64) func(outputSize int) LearnableActivation {
+	return func(outputSize int) LearnableActivation {
+		slopes := make([]*Value, outputSize)
+		for i := range slopes {
+			slopes[i] = MakeValue(alpha)
+		}
+		return &prelu{slopes: slopes}
+	}
+}
+
+type prelu struct {
+	slopes []*Value
+}
+
+func (p *prelu) Fit(input []*Value) []*Value {
+	ans := make([]*Value, len(input))
+	for i, x := range input {
+		ans[i] = leakyRelu(x, p.slopes[i])
+	}
+	return ans
+}
+
+func (p *prelu) Parameters() []*Value {
+	return p.slopes
+}
+
+// Leaky rectified linear unit with a trainable slope: y = x if x > 0 else a*x
+func leakyRelu(value, slope *Value) *Value {
+	data := value.data
+	if data <= 0.0 {
+		data *= slope.data
 	}
-	return MakeActivation("Exp", f, g)(value)
+	ans := &Value{
+		data:     data,
+		op:       "PReLU",
+		children: []*Value{value, slope},
+	}
+	ans.backward = func(grad float64, accumulate accumulator) {
+		if value.data > 0.0 {
+			accumulate(value, grad)
+			return
+		}
+		accumulate(value, slope.data*grad)
+		accumulate(slope, value.data*grad)
+	}
+	return ans
+}
+
+// Swish function: y = x * sigmoid(beta*x) where beta is shared by all
+// neurons of the layer, trainable and initialized to beta.
+func Swish(beta float64) func(outputSize int) LearnableActivation {
+	return func(outputSize int) LearnableActivation {
+		return &swish{beta: MakeValue(beta)}
+	}
+}
+
+type swish struct {
+	beta *Value
+}
+
+func (s *swish) Fit(input []*Value) []*Value {
+	ans := make([]*Value, len(input))
+	for i, x := range input {
+		ans[i] = x.Mul(Sigmoid(s.beta.Mul(x)))
+	}
+	return ans
+}
+
+func (s *swish) Parameters() []*Value {
+	return []*Value{s.beta}
 }
@@ -0,0 +1,152 @@
+package nn
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var activations = map[string]func(*Value) *Value{
+	"ReLU":      Relu,
+	"LeakyReLU": LeakyRelu(0.01),
+	"ELU":       Elu(1.0),
+	"SELU":      Selu,
+	"GELU":      Gelu,
+	"Sigmoid":   Sigmoid,
+	"SiLU":      Silu,
+	"Softplus":  Softplus,
+	"Mish":      Mish,
+	"Tanh":      Tanh,
+	"HardTanh":  HardTanh,
+	"Exp":       Exp,
+}
+
+// Compares the gradient computed by back propagation to central finite
+// differences. Points are chosen away from the kinks of the activations.
+func TestActivationGradient(t *testing.T) {
+	const eps = 1e-6
+	for op, activation := range activations {
+		for _, x := range []float64{-3.0, -0.5, 0.3, 0.7, 2.5} {
+			value := MakeValue(x)
+			y := activation(value)
+			y.BackPropagate()
+
+			assert.Equalf(t, op, y.GetOp(), "expected %s, got %s", op, y.GetOp())
+			expected := (activation(MakeValue(x+eps)).GetData() - activation(MakeValue(x-eps)).GetData()) / (2 * eps)
+			assert.InDelta(t, expected, value.GetGrad(), 1e-6, "%s'(%f): expected %f, got %f", op, x, expected, value.GetGrad())
+		}
+	}
+}
+
+func TestActivationStability(t *testing.T) {
+	for op, activation := range activations {
+		if op == "Exp" {
+			continue
+		}
+		for _, x := range []float64{-1000.0, 1000.0} {
+			value := MakeValue(x)
+			y := activation(value)
+			y.BackPropagate()
+			assert.Falsef(t, math.IsNaN(y.GetData()) || math.IsInf(y.GetData(), 0), "%s(%f) = %f", op, x, y.GetData())
+			assert.Falsef(t, math.IsNaN(value.GetGrad()) || math.IsInf(value.GetGrad(), 0), "%s'(%f) = %f", op, x, value.GetGrad())
+		}
+	}
+
+	assert.Equal(t, 1.0, Tanh(MakeValue(1000.0)).GetData())
+	assert.Equal(t, -1.0, Tanh(MakeValue(-1000.0)).GetData())
+	assert.Equal(t, 0.0, Sigmoid(MakeValue(-1000.0)).GetData())
+	assert.Equal(t, 1000.0, Softplus(MakeValue(1000.0)).GetData())
+}
+
+func TestActivationValues(t *testing.T) {
+	tests := []struct {
+		activation func(*Value) *Value
+		x, y       float64
+	}{
+		{LeakyRelu(0.1), -2.0, -0.2},
+		{Elu(2.0), -1.0, 2.0 * (math.Exp(-1.0) - 1.0)},
+		{Selu, 1.0, 1.0507009873554805},
+		{Gelu, 1.0, 0.841345},
+		{Silu, 1.0, 0.731059},
+		{Softplus, 0.0, math.Log(2.0)},
+		{Mish, 1.0, 0.865098},
+		{HardTanh, 3.0, 1.0},
+	}
+	for _, test := range tests {
+		y := test.activation(MakeValue(test.x)).GetData()
+		assert.InDelta(t, test.y, y, 1e-6, "expected %f, got %f", test.y, y)
+	}
+}
+
+func TestPRelu(t *testing.T) {
+	activation := PRelu(0.25)(2)
+	slopes := activation.Parameters()
+	assert.Equal(t, 2, len(slopes), "expected %d, got %d", 2, len(slopes))
+
+	x := []*Value{MakeValue(-2.0), MakeValue(3.0)}
+	y := activation.Fit(x)
+	y[0].Add(y[1]).BackPropagate()
+
+	assert.Equalf(t, -0.5, y[0].GetData(), "expected %f, got %f", -0.5, y[0].GetData())
+	assert.Equalf(t, 3.0, y[1].GetData(), "expected %f, got %f", 3.0, y[1].GetData())
+	assert.Equalf(t, 0.25, x[0].GetGrad(), "expected %f, got %f", 0.25, x[0].GetGrad())
+	assert.Equalf(t, 1.0, x[1].GetGrad(), "expected %f, got %f", 1.0, x[1].GetGrad())
+	assert.Equalf(t, -2.0, slopes[0].GetGrad(), "expected %f, got %f", -2.0, slopes[0].GetGrad())
+	assert.Equalf(t, 0.0, slopes[1].GetGrad(), "expected %f, got %f", 0.0, slopes[1].GetGrad())
+}
+
+func TestSwish(t *testing.T) {
+	const eps = 1e-6
+	activation := Swish(1.5)(1)
+	beta := activation.Parameters()[0]
+	x := MakeValue(0.7)
+	activation.Fit([]*Value{x})[0].BackPropagate()
+
+	f := func(x, beta float64) float64 {
+		return x * sigmoid(beta*x)
+	}
+	expected := (f(0.7+eps, 1.5) - f(0.7-eps, 1.5)) / (2 * eps)
+	assert.InDelta(t, expected, x.GetGrad(), 1e-6, "expected %f, got %f", expected, x.GetGrad())
+	expected = (f(0.7, 1.5+eps) - f(0.7, 1.5-eps)) / (2 * eps)
+	assert.InDelta(t, expected, beta.GetGrad(), 1e-6, "expected %f, got %f", expected, beta.GetGrad())
+}
+
+func TestSoftmax(t *testing.T) {
+	const eps = 1e-6
+	data := []float64{1.0, -2.0, 0.5}
+	weights := []float64{0.3, -1.2, 2.0}
+	// Weighted sum of the probabilities to check the gradient of all outputs.
+	f := func(data []float64) (*Value, []*Value) {
+		input := make([]*Value, len(data))
+		for i, x := range data {
+			input[i] = MakeValue(x)
+		}
+		y := MakeValue(0.0)
+		for i, p := range Softmax(input) {
+			y = y.Add(p.Mul(MakeValue(weights[i])))
+		}
+		return y, input
+	}
+
+	y, input := f(data)
+	y.BackPropagate()
+	for i := range data {
+		data[i] += eps
+		pos, _ := f(data)
+		data[i] -= 2 * eps
+		neg, _ := f(data)
+		data[i] += eps
+		expected := (pos.GetData() - neg.GetData()) / (2 * eps)
+		assert.InDelta(t, expected, input[i].GetGrad(), 1e-6, "expected %f, got %f", expected, input[i].GetGrad())
+	}
+
+	probs := Softmax([]*Value{MakeValue(1000.0), MakeValue(1000.0), MakeValue(-1000.0)})
+	sum := 0.0
+	for _, p := range probs {
+		assert.Falsef(t, math.IsNaN(p.GetData()), "expected a number, got %f", p.GetData())
+		sum += p.GetData()
+	}
+	assert.InDelta(t, 1.0, sum, 1e-12, "expected %f, got %f", 1.0, sum)
+	assert.InDelta(t, 0.5, probs[0].GetData(), 1e-12, "expected %f, got %f", 0.5, probs[0].GetData())
+}
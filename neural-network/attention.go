@@ -0,0 +1,260 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Attention modules work on sequences given as a flat input of T positions
+// of dModel values each, so sequences of any length can be fed. Outputs use
+// the same layout.
+
+// Splits a flat input into parts of a given size.
+func split(input []*Value, size int) [][]*Value {
+	if len(input)%size != 0 {
+		panic(fmt.Sprintf("expected a multiple of %d input values, got %d", size, len(input)))
+	}
+	parts := make([][]*Value, len(input)/size)
+	for i := range parts {
+		parts[i] = input[i*size : (i+1)*size]
+	}
+	return parts
+}
+
+// Computes scaled dot-product attention of each query over all keys:
+//
+//	output_i = sum_j softmax_j(q_i . k_j / sqrt(d)) v_j
+//
+// where d is the size of the keys. If causal is true, position i only attends
+// to positions j <= i.
+func ScaledDotProductAttention(queries, keys, values [][]*Value, causal bool) [][]*Value {
+	ans := make([][]*Value, len(queries))
+	for i, q := range queries {
+		n := len(keys)
+		if causal {
+			n = i + 1
+		}
+		scale := MakeValue(1.0 / math.Sqrt(float64(len(q))))
+		scores := make([]*Value, n)
+		for j, k := range keys[:n] {
+			scores[j] = dotProduct(q, k).Mul(scale)
+		}
+		weights := Softmax(scores)
+		ans[i] = make([]*Value, len(values[0]))
+		for d := range ans[i] {
+			sum := MakeValue(0.0)
+			for j, weight := range weights {
+				sum = sum.Add(weight.Mul(values[j][d]))
+			}
+			ans[i][d] = sum
+		}
+	}
+	return ans
+}
+
+func dotProduct(a, b []*Value) *Value {
+	ans := MakeValue(0.0)
+	for i := range a {
+		ans = ans.Add(a[i].Mul(b[i]))
+	}
+	return ans
+}
+
+// Applies a module on each part of a flat input and concatenates the outputs.
+func forwardEach(module Module, parts [][]*Value) [][]*Value {
+	ans := make([][]*Value, len(parts))
+	for i, part := range parts {
+		ans[i] = module.Forward(part)
+	}
+	return ans
+}
+
+// Multi-head attention module. Queries, keys and values of each position are
+// linear projections of the input, which are split into heads of size
+// dModel/numHeads. Scaled dot-product attention is computed for each head
+// and the concatenated outputs of the heads are projected back.
+type MultiHeadAttention struct {
+	dModel, numHeads int
+	causal           bool
+	query, key       *Layer
+	value, output    *Layer
+}
+
+// Makes a multi-head self-attention module. dModel must be divisible by
+// numHeads. If causal is true, each position only attends to itself and
+// the previous positions.
+func MakeMultiHeadAttention(dModel, numHeads int, causal bool, rng *rand.Rand) *MultiHeadAttention {
+	if dModel%numHeads != 0 {
+		panic(fmt.Sprintf("dModel %d is not divisible by %d heads", dModel, numHeads))
+	}
+	linear := func() *Layer {
+		return MakeLayer(dModel, MakeLayerParam(dModel, nil), rng)
+	}
+	return &MultiHeadAttention{
+		dModel:   dModel,
+		numHeads: numHeads,
+		causal:   causal,
+		query:    linear(),
+		key:      linear(),
+		value:    linear(),
+		output:   linear(),
+	}
+}
+
+// Computes self-attention over the positions of the input.
+func (m *MultiHeadAttention) Forward(input []*Value) []*Value {
+	positions := split(input, m.dModel)
+	queries := forwardEach(m.query, positions)
+	keys := forwardEach(m.key, positions)
+	values := forwardEach(m.value, positions)
+
+	size := m.dModel / m.numHeads
+	heads := make([][]*Value, len(positions))
+	for h := 0; h < m.numHeads; h++ {
+		head := func(x [][]*Value) [][]*Value {
+			ans := make([][]*Value, len(x))
+			for i := range x {
+				ans[i] = x[i][h*size : (h+1)*size]
+			}
+			return ans
+		}
+		outputs := ScaledDotProductAttention(head(queries), head(keys), head(values), m.causal)
+		for i := range heads {
+			heads[i] = append(heads[i], outputs[i]...)
+		}
+	}
+	return concat(forwardEach(m.output, heads)...)
+}
+
+// Returns the parameters of the query, key, value and output projections.
+func (m *MultiHeadAttention) Parameters() []*Value {
+	return concat(m.query.Parameters(), m.key.Parameters(), m.value.Parameters(), m.output.Parameters())
+}
+
+// Returns the neurons of the query, key, value and output projections.
+func (m *MultiHeadAttention) Neurons() []*Neuron {
+	return neurons([]Module{m.query, m.key, m.value, m.output})
+}
+
+// Returns a copy of the module with new parameters of the same values.
+func (m *MultiHeadAttention) Clone() Module {
+	return &MultiHeadAttention{
+		dModel:   m.dModel,
+		numHeads: m.numHeads,
+		causal:   m.causal,
+		query:    cloneLayer(m.query),
+		key:      cloneLayer(m.key),
+		value:    cloneLayer(m.value),
+		output:   cloneLayer(m.output),
+	}
+}
+
+// Positional encoding module adding sinusoidal encodings of the positions to
+// the input:
+//
+//	PE(pos, 2i) = sin(pos / 10000^(2i/dModel))
+//	PE(pos, 2i+1) = cos(pos / 10000^(2i/dModel))
+type PositionalEncoding struct {
+	dModel int
+}
+
+// Makes a positional encoding module for positions of dModel values.
+func MakePositionalEncoding(dModel int) *PositionalEncoding {
+	return &PositionalEncoding{
+		dModel: dModel,
+	}
+}
+
+// Adds the encoding of each position to its values.
+func (p *PositionalEncoding) Forward(input []*Value) []*Value {
+	ans := make([]*Value, 0, len(input))
+	for pos, values := range split(input, p.dModel) {
+		for i, x := range values {
+			angle := float64(pos) / math.Pow(10000.0, float64(i-i%2)/float64(p.dModel))
+			encoding := math.Sin(angle)
+			if i%2 == 1 {
+				encoding = math.Cos(angle)
+			}
+			ans = append(ans, x.Add(MakeValue(encoding)))
+		}
+	}
+	return ans
+}
+
+// Positional encoding has no parameters.
+func (p *PositionalEncoding) Parameters() []*Value {
+	return nil
+}
+
+// Transformer encoder block. Multi-head self-attention and a position-wise
+// feed-forward network are each followed by a residual connection and layer
+// normalization:
+//
+//	x = LayerNorm(x + MultiHeadAttention(x))
+//	y = LayerNorm(x + W_2 relu(W_1 x + b_1) + b_2)
+type TransformerBlock struct {
+	dModel                  int
+	attention               *MultiHeadAttention
+	hidden, output          *Layer
+	attentionNorm, feedNorm *LayerNorm
+}
+
+// Makes a transformer encoder block with a feed-forward network of a given
+// hidden size. If causal is true, attention is masked so each position only
+// depends on itself and the previous positions.
+func MakeTransformerBlock(dModel, numHeads, hiddenSize int, causal bool, rng *rand.Rand) *TransformerBlock {
+	return &TransformerBlock{
+		dModel:        dModel,
+		attention:     MakeMultiHeadAttention(dModel, numHeads, causal, rng),
+		hidden:        MakeLayer(dModel, MakeLayerParam(hiddenSize, Relu), rng),
+		output:        MakeLayer(hiddenSize, MakeLayerParam(dModel, nil), rng),
+		attentionNorm: MakeLayerNorm(dModel),
+		feedNorm:      MakeLayerNorm(dModel),
+	}
+}
+
+// Computes the outputs of the block for all positions of the input.
+func (b *TransformerBlock) Forward(input []*Value) []*Value {
+	attended := split(b.attention.Forward(input), b.dModel)
+	ans := make([]*Value, 0, len(input))
+	for i, x := range split(input, b.dModel) {
+		x = b.attentionNorm.Forward(add(x, attended[i]))
+		y := b.output.Fit(b.hidden.Fit(x))
+		ans = append(ans, b.feedNorm.Forward(add(x, y))...)
+	}
+	return ans
+}
+
+// Returns the parameters of attention, the feed-forward network and layer
+// normalizations.
+func (b *TransformerBlock) Parameters() []*Value {
+	return concat(b.attention.Parameters(), b.attentionNorm.Parameters(),
+		b.hidden.Parameters(), b.output.Parameters(), b.feedNorm.Parameters())
+}
+
+// Returns the neurons of attention and the feed-forward network.
+func (b *TransformerBlock) Neurons() []*Neuron {
+	return neurons([]Module{b.attention, b.hidden, b.output})
+}
+
+// Returns a copy of the block with new parameters of the same values.
+func (b *TransformerBlock) Clone() Module {
+	return &TransformerBlock{
+		dModel:        b.dModel,
+		attention:     b.attention.Clone().(*MultiHeadAttention),
+		hidden:        cloneLayer(b.hidden),
+		output:        cloneLayer(b.output),
+		attentionNorm: b.attentionNorm.Clone().(*LayerNorm),
+		feedNorm:      b.feedNorm.Clone().(*LayerNorm),
+	}
+}
+
+// Returns the element-wise sum of a and b.
+func add(a, b []*Value) []*Value {
+	ans := make([]*Value, len(a))
+	for i := range a {
+		ans[i] = a[i].Add(b[i])
+	}
+	return ans
+}
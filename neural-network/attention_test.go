@@ -0,0 +1,86 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaledDotProductAttention(t *testing.T) {
+	queries := makeRecords([][]float64{{1.0, 0.0}, {0.0, 2.0}})
+	keys := makeRecords([][]float64{{2.0, 0.0}, {0.0, 1.0}})
+	values := makeRecords([][]float64{{1.0}, {3.0}})
+
+	outputs := ScaledDotProductAttention(queries, keys, values, false)
+	// Scores of the first query are (2, 0)/sqrt(2) and of the second (0, 2)/sqrt(2).
+	w := 1.0 / (1.0 + math.Exp(-math.Sqrt2))
+	expected := []float64{w*1.0 + (1-w)*3.0, (1-w)*1.0 + w*3.0}
+	for i, output := range outputs {
+		assert.InDelta(t, expected[i], output[0].GetData(), 1e-12, "expected %f, got %f", expected[i], output[0].GetData())
+	}
+
+	// With causal masking, the first position only attends to itself.
+	outputs = ScaledDotProductAttention(queries, keys, values, true)
+	assert.InDelta(t, 1.0, outputs[0][0].GetData(), 1e-12, "expected %f, got %f", 1.0, outputs[0][0].GetData())
+	assert.InDelta(t, expected[1], outputs[1][0].GetData(), 1e-12, "expected %f, got %f", expected[1], outputs[1][0].GetData())
+
+	all := concat(concat(queries...), concat(keys...), concat(values...))
+	checkGradient(t, all, func() *Value {
+		return weightedSum(ScaledDotProductAttention(queries, keys, values, true)...)
+	})
+}
+
+func TestMultiHeadAttention(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	attention := MakeMultiHeadAttention(4, 2, false, rng)
+	input := makeInput(3 * 4)
+	assert.Equal(t, 12, len(attention.Forward(input)), "expected %d, got %d", 12, len(attention.Forward(input)))
+	assert.Equal(t, 4*(4*4+4), len(attention.Parameters()), "expected %d, got %d", 4*(4*4+4), len(attention.Parameters()))
+	checkGradient(t, append(attention.Parameters(), input...), func() *Value {
+		return weightedSum(attention.Forward(input))
+	})
+
+	assert.Panics(t, func() { MakeMultiHeadAttention(4, 3, false, rng) })
+	assert.Panics(t, func() { attention.Forward(input[1:]) })
+}
+
+func TestPositionalEncoding(t *testing.T) {
+	input := makeRecords([][]float64{{0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0}})[0]
+	output := MakePositionalEncoding(4).Forward(input)
+	expected := []float64{0.0, 1.0, 0.0, 1.0, math.Sin(1.0), math.Cos(1.0), math.Sin(0.01), math.Cos(0.01)}
+	for i, y := range output {
+		assert.InDelta(t, expected[i], y.GetData(), 1e-12, "expected %f, got %f", expected[i], y.GetData())
+	}
+	assert.Empty(t, MakePositionalEncoding(4).Parameters())
+}
+
+func TestTransformerBlock(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	block := MakeTransformerBlock(4, 2, 6, true, rng)
+	input := makeInput(3 * 4)
+	output := block.Forward(input)
+	assert.Equal(t, 12, len(output), "expected %d, got %d", 12, len(output))
+	checkGradient(t, append(block.Parameters(), input...), func() *Value {
+		return weightedSum(block.Forward(input))
+	})
+
+	// With causal masking, changing the last position does not change the
+	// outputs of the previous positions.
+	input[11].SetData(5.0)
+	changed := block.Forward(input)
+	for i := 0; i < 8; i++ {
+		assert.InDelta(t, output[i].GetData(), changed[i].GetData(), 1e-12, "expected %f, got %f", output[i].GetData(), changed[i].GetData())
+	}
+	assert.NotEqual(t, output[11].GetData(), changed[11].GetData())
+
+	model := MakeModel(MakePositionalEncoding(4), block, MakeLayer(12, MakeLayerParam(1, Sigmoid), rng))
+	inputs := [][]*Value{makeInput(12), makeInput(12)}
+	inputs[1][0].SetData(1.0)
+	labels := makeRecords([][]float64{{0.0}, {1.0}})
+	losses, _, _ := model.Train(inputs, labels, TrainingParam{Epochs: 10, LearningRate: 0.1})
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+}
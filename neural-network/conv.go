@@ -0,0 +1,413 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Convolution and pooling modules work on flat inputs holding multiple
+// channels one after another. A 1D input of length L with C channels has
+// C*L values where x[c*L + t] is the value of channel c at position t. A 2D
+// input of height H and width W with C channels has C*H*W values where
+// x[(c*H + h)*W + w] is the value of channel c at row h and column w. Outputs
+// use the same layout, so they can be fed to dense layers directly.
+
+// Parameters of convolution and pooling modules. The kernel size, stride and
+// padding are the same along all spatial dimensions. Pooling modules keep the
+// number of channels and ignore OutChannels.
+type ConvParam struct {
+	InChannels, OutChannels int
+	KernelSize              int
+	// Defaults to 1 for convolution and to KernelSize for pooling if zero.
+	Stride int
+	// Number of zeros added at both ends of each spatial dimension. Padded
+	// positions are ignored by pooling.
+	Padding int
+}
+
+// A sliding window over a 2D input of one channel.
+type window struct {
+	height, width             int
+	kernelHeight, kernelWidth int
+	strideHeight, strideWidth int
+	padHeight, padWidth       int
+}
+
+// Makes a window over a 1D input of a given length.
+func makeWindow1D(length int, param ConvParam, stride int) window {
+	return window{
+		height:       1,
+		width:        length,
+		kernelHeight: 1,
+		kernelWidth:  param.KernelSize,
+		strideHeight: 1,
+		strideWidth:  stride,
+		padWidth:     param.Padding,
+	}
+}
+
+// Makes a window over a 2D input of a given height and width.
+func makeWindow2D(height, width int, param ConvParam, stride int) window {
+	return window{
+		height:       height,
+		width:        width,
+		kernelHeight: param.KernelSize,
+		kernelWidth:  param.KernelSize,
+		strideHeight: stride,
+		strideWidth:  stride,
+		padHeight:    param.Padding,
+		padWidth:     param.Padding,
+	}
+}
+
+// Returns the height and width of the output.
+func (w window) outputSize() (int, int) {
+	height := (w.height+2*w.padHeight-w.kernelHeight)/w.strideHeight + 1
+	width := (w.width+2*w.padWidth-w.kernelWidth)/w.strideWidth + 1
+	return height, width
+}
+
+// Calls f for each position of the kernel placed at output position (row,
+// col) which falls inside the input, where k is the index of the position in
+// the kernel and i is the index of the position in the input.
+func (w window) each(row, col int, f func(k, i int)) {
+	for kh := 0; kh < w.kernelHeight; kh++ {
+		h := row*w.strideHeight + kh - w.padHeight
+		if h < 0 || h >= w.height {
+			continue
+		}
+		for kw := 0; kw < w.kernelWidth; kw++ {
+			x := col*w.strideWidth + kw - w.padWidth
+			if x < 0 || x >= w.width {
+				continue
+			}
+			f(kh*w.kernelWidth+kw, h*w.width+x)
+		}
+	}
+}
+
+// Panics if param is invalid. Convolutions also need output channels.
+func validateConvParam(param ConvParam, convolution bool) {
+	if param.KernelSize < 1 || param.InChannels < 1 {
+		panic(fmt.Sprintf("expected a positive kernel size and number of input channels, got %d and %d", param.KernelSize, param.InChannels))
+	}
+	if convolution && param.OutChannels < 1 {
+		panic(fmt.Sprintf("expected a positive number of output channels, got %d", param.OutChannels))
+	}
+	if param.Stride < 0 || param.Padding < 0 || param.Padding >= param.KernelSize {
+		panic(fmt.Sprintf("expected a non-negative stride and padding less than the kernel size %d, got %d and %d", param.KernelSize, param.Stride, param.Padding))
+	}
+}
+
+// Panics if the kernel of a window does not fit in the padded input.
+func validateWindow(w window) {
+	if w.height == 1 && w.kernelHeight == 1 && w.width+2*w.padWidth < w.kernelWidth {
+		panic(fmt.Sprintf("kernel of size %d does not fit in an input of length %d", w.kernelWidth, w.width))
+	}
+	if w.height+2*w.padHeight < w.kernelHeight || w.width+2*w.padWidth < w.kernelWidth {
+		panic(fmt.Sprintf("kernel of size %dx%d does not fit in an input of size %dx%d", w.kernelHeight, w.kernelWidth, w.height, w.width))
+	}
+}
+
+func defaultStride(stride, otherwise int) int {
+	if stride > 0 {
+		return stride
+	}
+	return otherwise
+}
+
+// Makes a neuron per output channel with a weight per input channel and
+// kernel position.
+func makeFilters(param ConvParam, kernelSize int, rng *rand.Rand) []*Neuron {
+	filters := make([]*Neuron, param.OutChannels)
+	for i := range filters {
+		filters[i] = MakeNeuron(param.InChannels*kernelSize, rng)
+	}
+	return filters
+}
+
+// Computes the convolution of the input with filters over a window.
+func convolve(filters []*Neuron, inChannels int, w window, input []*Value) []*Value {
+	size := w.height * w.width
+	if len(input) != inChannels*size {
+		panic(fmt.Sprintf("convolution expects %d input values, got %d", inChannels*size, len(input)))
+	}
+	kernelSize := w.kernelHeight * w.kernelWidth
+	height, width := w.outputSize()
+	ans := make([]*Value, 0, len(filters)*height*width)
+	for _, filter := range filters {
+		for row := 0; row < height; row++ {
+			for col := 0; col < width; col++ {
+				sum := filter.intercept
+				for c := 0; c < inChannels; c++ {
+					w.each(row, col, func(k, i int) {
+						sum = sum.Add(input[c*size+i].Mul(filter.weights[c*kernelSize+k]))
+					})
+				}
+				ans = append(ans, sum)
+			}
+		}
+	}
+	return ans
+}
+
+func filterParameters(filters []*Neuron) []*Value {
+	params := []*Value{}
+	for _, filter := range filters {
+		params = append(params, filter.intercept)
+		params = append(params, filter.weights...)
+	}
+	return params
+}
+
+// 1D convolution module. The length of the input is inferred from the
+// number of input values.
+type Conv1D struct {
+	param   ConvParam
+	filters []*Neuron
+}
+
+// Makes a 1D convolution module. Weights and intercepts are initialized to
+// random numbers from the standard normal distribution drawn from rng.
+func MakeConv1D(param ConvParam, rng *rand.Rand) *Conv1D {
+	validateConvParam(param, true)
+	param.Stride = defaultStride(param.Stride, 1)
+	return &Conv1D{
+		param:   param,
+		filters: makeFilters(param, param.KernelSize, rng),
+	}
+}
+
+// Returns the output length for an input of a given length.
+func (c *Conv1D) OutputLength(length int) int {
+	w := makeWindow1D(length, c.param, c.param.Stride)
+	validateWindow(w)
+	_, width := w.outputSize()
+	return width
+}
+
+// Computes the convolution of the input with each filter.
+func (c *Conv1D) Forward(input []*Value) []*Value {
+	w := makeWindow1D(len(input)/c.param.InChannels, c.param, c.param.Stride)
+	validateWindow(w)
+	return convolve(c.filters, c.param.InChannels, w, input)
+}
+
+// Returns the intercept and weights of each filter.
+func (c *Conv1D) Parameters() []*Value {
+	return filterParameters(c.filters)
+}
+
+// Returns the filters of the module.
+func (c *Conv1D) Neurons() []*Neuron {
+	return c.filters
+}
+
+// Returns a copy of the module with new filters of the same values.
+func (c *Conv1D) Clone() Module {
+	return &Conv1D{
+		param:   c.param,
+		filters: cloneNeurons(c.filters),
+	}
+}
+
+// 2D convolution module with square kernels.
+type Conv2D struct {
+	window     window
+	inChannels int
+	filters    []*Neuron
+}
+
+// Makes a 2D convolution module for inputs of a given height and width.
+// Weights and intercepts are initialized to random numbers from the standard
+// normal distribution drawn from rng.
+func MakeConv2D(height, width int, param ConvParam, rng *rand.Rand) *Conv2D {
+	validateConvParam(param, true)
+	w := makeWindow2D(height, width, param, defaultStride(param.Stride, 1))
+	validateWindow(w)
+	return &Conv2D{
+		window:     w,
+		inChannels: param.InChannels,
+		filters:    makeFilters(param, param.KernelSize*param.KernelSize, rng),
+	}
+}
+
+// Returns the number of channels, height and width of the output.
+func (c *Conv2D) OutputShape() (int, int, int) {
+	height, width := c.window.outputSize()
+	return len(c.filters), height, width
+}
+
+// Computes the convolution of the input with each filter.
+func (c *Conv2D) Forward(input []*Value) []*Value {
+	return convolve(c.filters, c.inChannels, c.window, input)
+}
+
+// Returns the intercept and weights of each filter.
+func (c *Conv2D) Parameters() []*Value {
+	return filterParameters(c.filters)
+}
+
+// Returns the filters of the module.
+func (c *Conv2D) Neurons() []*Neuron {
+	return c.filters
+}
+
+// Returns a copy of the module with new filters of the same values.
+func (c *Conv2D) Clone() Module {
+	return &Conv2D{
+		window:     c.window,
+		inChannels: c.inChannels,
+		filters:    cloneNeurons(c.filters),
+	}
+}
+
+// Pooling over windows of each channel. If is1D is true, the length of the
+// input is inferred from the number of input values.
+type pool struct {
+	param  ConvParam
+	window window
+	is1D   bool
+	reduce func([]*Value) *Value
+}
+
+func makePool1D(param ConvParam, reduce func([]*Value) *Value) pool {
+	validateConvParam(param, false)
+	param.Stride = defaultStride(param.Stride, param.KernelSize)
+	return pool{
+		param:  param,
+		is1D:   true,
+		reduce: reduce,
+	}
+}
+
+func makePool2D(height, width int, param ConvParam, reduce func([]*Value) *Value) pool {
+	validateConvParam(param, false)
+	param.Stride = defaultStride(param.Stride, param.KernelSize)
+	w := makeWindow2D(height, width, param, param.Stride)
+	validateWindow(w)
+	return pool{
+		param:  param,
+		window: w,
+		reduce: reduce,
+	}
+}
+
+func (p pool) Forward(input []*Value) []*Value {
+	channels := p.param.InChannels
+	w := p.window
+	if p.is1D {
+		w = makeWindow1D(len(input)/channels, p.param, p.param.Stride)
+		validateWindow(w)
+	}
+	size := w.height * w.width
+	if len(input) != channels*size {
+		panic(fmt.Sprintf("pooling expects %d input values, got %d", channels*size, len(input)))
+	}
+	height, width := w.outputSize()
+	ans := make([]*Value, 0, channels*height*width)
+	for c := 0; c < channels; c++ {
+		for row := 0; row < height; row++ {
+			for col := 0; col < width; col++ {
+				values := []*Value{}
+				w.each(row, col, func(k, i int) {
+					values = append(values, input[c*size+i])
+				})
+				ans = append(ans, p.reduce(values))
+			}
+		}
+	}
+	return ans
+}
+
+// Pooling has no parameters.
+func (p pool) Parameters() []*Value {
+	return nil
+}
+
+// Returns the output length of 1D pooling for an input of a given length.
+// Panics for 2D pooling.
+func (p pool) OutputLength(length int) int {
+	if !p.is1D {
+		panic("OutputLength is only defined for 1D pooling, use OutputShape")
+	}
+	w := makeWindow1D(length, p.param, p.param.Stride)
+	validateWindow(w)
+	_, width := w.outputSize()
+	return width
+}
+
+// Returns the number of channels, height and width of the output of 2D
+// pooling. Panics for 1D pooling.
+func (p pool) OutputShape() (int, int, int) {
+	if p.is1D {
+		panic("OutputShape is only defined for 2D pooling, use OutputLength")
+	}
+	height, width := p.window.outputSize()
+	return p.param.InChannels, height, width
+}
+
+// Max pooling module computing the maximum of each window of each channel.
+type MaxPool struct {
+	pool
+}
+
+// Makes a 1D max pooling module. The stride defaults to the kernel size.
+func MakeMaxPool1D(param ConvParam) *MaxPool {
+	return &MaxPool{makePool1D(param, maxOf)}
+}
+
+// Makes a 2D max pooling module for inputs of a given height and width. The
+// stride defaults to the kernel size.
+func MakeMaxPool2D(height, width int, param ConvParam) *MaxPool {
+	return &MaxPool{makePool2D(height, width, param, maxOf)}
+}
+
+func maxOf(values []*Value) *Value {
+	return Max(values...)
+}
+
+// Average pooling module computing the mean of each window of each channel.
+// Padded positions are not counted.
+type AvgPool struct {
+	pool
+}
+
+// Makes a 1D average pooling module. The stride defaults to the kernel size.
+func MakeAvgPool1D(param ConvParam) *AvgPool {
+	return &AvgPool{makePool1D(param, meanOf)}
+}
+
+// Makes a 2D average pooling module for inputs of a given height and width.
+// The stride defaults to the kernel size.
+func MakeAvgPool2D(height, width int, param ConvParam) *AvgPool {
+	return &AvgPool{makePool2D(height, width, param, meanOf)}
+}
+
+func meanOf(values []*Value) *Value {
+	sum := values[0]
+	for _, value := range values[1:] {
+		sum = sum.Add(value)
+	}
+	return sum.Mul(MakeValue(1.0 / float64(len(values))))
+}
+
+// Flatten module connecting convolution or pooling modules to dense layers.
+// Since all modules use flat inputs and outputs in the channel-major layout,
+// it returns the input unchanged and only documents the change of shape.
+type Flatten struct{}
+
+// Makes a Flatten module.
+func MakeFlatten() *Flatten {
+	return &Flatten{}
+}
+
+// Returns the input values.
+func (f *Flatten) Forward(input []*Value) []*Value {
+	return input
+}
+
+// Flatten has no parameters.
+func (f *Flatten) Parameters() []*Value {
+	return nil
+}
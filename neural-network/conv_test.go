@@ -0,0 +1,164 @@
+package nn
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Makes input values 0.1, 0.2, ... with alternating signs.
+func makeInput(size int) []*Value {
+	input := make([]*Value, size)
+	for i := range input {
+		x := 0.1 * float64(i+1)
+		if i%2 == 1 {
+			x = -x
+		}
+		input[i] = MakeValue(x)
+	}
+	return input
+}
+
+func TestConv1D(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	conv := MakeConv1D(ConvParam{InChannels: 1, OutChannels: 1, KernelSize: 2, Padding: 1}, rng)
+	params := conv.Parameters()
+	for i, x := range []float64{0.5, 1.0, -1.0} {
+		params[i].SetData(x)
+	}
+	// Padded input: 0, 1, 2, 4, 0
+	output := conv.Forward([]*Value{MakeValue(1.0), MakeValue(2.0), MakeValue(4.0)})
+	expected := []float64{-0.5, -0.5, -1.5, 4.5}
+	assert.Equal(t, 4, conv.OutputLength(3), "expected %d, got %d", 4, conv.OutputLength(3))
+	assert.Equal(t, len(expected), len(output), "expected %d, got %d", len(expected), len(output))
+	for i, y := range output {
+		assert.InDelta(t, expected[i], y.GetData(), 1e-12, "expected %f, got %f", expected[i], y.GetData())
+	}
+
+	conv = MakeConv1D(ConvParam{InChannels: 2, OutChannels: 3, KernelSize: 3, Stride: 2, Padding: 1}, rng)
+	input := makeInput(2 * 7)
+	assert.Equal(t, 3*4, len(conv.Forward(input)), "expected %d, got %d", 3*4, len(conv.Forward(input)))
+	checkGradient(t, append(conv.Parameters(), input...), func() *Value {
+		return weightedSum(conv.Forward(input))
+	})
+
+	// Inputs shorter than the kernel.
+	conv = MakeConv1D(ConvParam{InChannels: 1, OutChannels: 1, KernelSize: 3}, rng)
+	assert.Panics(t, func() { conv.Forward(makeInput(2)) })
+	assert.Panics(t, func() { conv.OutputLength(2) })
+	maxPool := MakeMaxPool1D(ConvParam{InChannels: 1, KernelSize: 3})
+	assert.Panics(t, func() { maxPool.Forward(makeInput(2)) })
+	assert.Panics(t, func() { maxPool.OutputLength(2) })
+}
+
+func TestConv2D(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	conv := MakeConv2D(4, 5, ConvParam{InChannels: 2, OutChannels: 2, KernelSize: 3, Stride: 2, Padding: 1}, rng)
+	channels, height, width := conv.OutputShape()
+	assert.Equal(t, []int{2, 2, 3}, []int{channels, height, width})
+
+	input := makeInput(2 * 4 * 5)
+	output := conv.Forward(input)
+	assert.Equal(t, 2*2*3, len(output), "expected %d, got %d", 2*2*3, len(output))
+	checkGradient(t, append(conv.Parameters(), input...), func() *Value {
+		return weightedSum(conv.Forward(input))
+	})
+
+	assert.Panics(t, func() { conv.Forward(input[1:]) })
+}
+
+func TestPool(t *testing.T) {
+	// Two channels of 2x3.
+	input := []*Value{
+		MakeValue(1.0), MakeValue(5.0), MakeValue(2.0),
+		MakeValue(-1.0), MakeValue(3.0), MakeValue(0.0),
+
+		MakeValue(-2.0), MakeValue(-3.0), MakeValue(6.0),
+		MakeValue(4.0), MakeValue(1.0), MakeValue(2.0),
+	}
+	maxPool := MakeMaxPool2D(2, 3, ConvParam{InChannels: 2, KernelSize: 2})
+	channels, height, width := maxPool.OutputShape()
+	assert.Equal(t, []int{2, 1, 1}, []int{channels, height, width})
+	output := maxPool.Forward(input)
+	assert.Equal(t, []float64{5.0, 4.0}, []float64{output[0].GetData(), output[1].GetData()})
+
+	// Padded positions are ignored.
+	avgPool := MakeAvgPool2D(2, 3, ConvParam{InChannels: 2, KernelSize: 2, Padding: 1})
+	channels, height, width = avgPool.OutputShape()
+	assert.Equal(t, []int{2, 2, 2}, []int{channels, height, width})
+	output = avgPool.Forward(input)
+	expected := []float64{1.0, 3.5, -1.0, 1.5, -2.0, 1.5, 4.0, 1.5}
+	for i, y := range output {
+		assert.InDelta(t, expected[i], y.GetData(), 1e-12, "expected %f, got %f", expected[i], y.GetData())
+	}
+	checkGradient(t, input, func() *Value {
+		return weightedSum(avgPool.Forward(input))
+	})
+	checkGradient(t, input, func() *Value {
+		return weightedSum(maxPool.Forward(input))
+	})
+
+	// 1D pooling over both channels of length 6.
+	maxPool = MakeMaxPool1D(ConvParam{InChannels: 2, KernelSize: 3, Stride: 2})
+	assert.Equal(t, 2, maxPool.OutputLength(6), "expected %d, got %d", 2, maxPool.OutputLength(6))
+	output = maxPool.Forward(input)
+	expected = []float64{5.0, 3.0, 6.0, 6.0}
+	for i, y := range output {
+		assert.InDelta(t, expected[i], y.GetData(), 1e-12, "expected %f, got %f", expected[i], y.GetData())
+	}
+	output = MakeAvgPool1D(ConvParam{InChannels: 2, KernelSize: 3}).Forward(input)
+	expected = []float64{8.0 / 3, 2.0 / 3, 1.0 / 3, 7.0 / 3}
+	for i, y := range output {
+		assert.InDelta(t, expected[i], y.GetData(), 1e-12, "expected %f, got %f", expected[i], y.GetData())
+	}
+	// Shapes are only defined for the dimension of the pooling.
+	assert.Panics(t, func() { maxPool.OutputShape() })
+	assert.Panics(t, func() { avgPool.OutputLength(6) })
+}
+
+func TestInvalidConvParam(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	for name, param := range map[string]ConvParam{
+		"kernel size":     {InChannels: 1, OutChannels: 1},
+		"input channels":  {OutChannels: 1, KernelSize: 2},
+		"padding":         {InChannels: 1, OutChannels: 1, KernelSize: 2, Padding: 2},
+		"negative stride": {InChannels: 1, OutChannels: 1, KernelSize: 2, Stride: -1},
+	} {
+		assert.Panics(t, func() { MakeConv1D(param, rng) }, name)
+		assert.Panics(t, func() { MakeConv2D(4, 4, param, rng) }, name)
+		assert.Panics(t, func() { MakeMaxPool1D(param) }, name)
+		assert.Panics(t, func() { MakeAvgPool2D(4, 4, param) }, name)
+	}
+	assert.Panics(t, func() { MakeConv1D(ConvParam{InChannels: 1, KernelSize: 2}, rng) }, "output channels")
+	assert.Panics(t, func() { MakeMaxPool2D(2, 2, ConvParam{InChannels: 1, KernelSize: 3}) }, "kernel larger than input")
+	// Pooling ignores the output channels.
+	assert.NotPanics(t, func() { MakeMaxPool1D(ConvParam{InChannels: 1, KernelSize: 2, Padding: 1}) })
+}
+
+func TestConvNetwork(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	conv := MakeConv2D(4, 4, ConvParam{InChannels: 1, OutChannels: 2, KernelSize: 3, Padding: 1}, rng)
+	pool := MakeMaxPool2D(4, 4, ConvParam{InChannels: 2, KernelSize: 2})
+	channels, height, width := pool.OutputShape()
+	model := MakeModel(
+		conv,
+		MakeSequential(elementwiseModule(Relu), pool),
+		MakeFlatten(),
+		MakeLayer(channels*height*width, MakeLayerParam(1, Sigmoid), rng),
+	)
+	// Vertical and horizontal lines.
+	inputs := [][]*Value{makeInput(16), makeInput(16)}
+	for i := 0; i < 4; i++ {
+		inputs[0][i*4+1].SetData(1.0)
+		inputs[1][4+i].SetData(1.0)
+	}
+	labels := [][]*Value{{MakeValue(0.0)}, {MakeValue(1.0)}}
+	losses, scores, _ := model.Train(inputs, labels, TrainingParam{Epochs: 20, LearningRate: 0.1, ClassificationThreshold: 0.5})
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+	accuracy := Accuracy(scores, labels, TrainingParam{ClassificationThreshold: 0.5})
+	assert.Equal(t, 1.0, accuracy, "expected %f, got %f", 1.0, accuracy)
+}
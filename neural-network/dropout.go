@@ -0,0 +1,70 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Dropout module. In training mode, each input value is set to zero with
+// probability p and the others are scaled by 1/(1-p), so the expected output
+// equals the input. In inference mode, it outputs the input unchanged.
+// Modules are in inference mode until switched by SetTraining.
+//
+// The random number generator is not safe for concurrent use, so forward
+// passes in training mode must not run concurrently.
+type Dropout struct {
+	p        float64
+	rng      *rand.Rand
+	training bool
+}
+
+// Makes a dropout module dropping each value with probability p in [0, 1)
+// using the given random number generator, e.g.
+// rand.New(rand.NewSource(seed)). Panics if p is out of range.
+func MakeDropout(p float64, rng *rand.Rand) *Dropout {
+	if p < 0.0 || p >= 1.0 {
+		panic(fmt.Sprintf("expected a dropout probability in [0, 1), got %f", p))
+	}
+	return &Dropout{
+		p:   p,
+		rng: rng,
+	}
+}
+
+// Masks the input values randomly in training mode.
+func (d *Dropout) Forward(input []*Value) []*Value {
+	if !d.training || d.p == 0.0 {
+		return input
+	}
+	scale := MakeValue(1.0 / (1.0 - d.p))
+	zero := MakeValue(0.0)
+	ans := make([]*Value, len(input))
+	for i, x := range input {
+		if d.rng.Float64() < d.p {
+			ans[i] = x.Mul(zero)
+		} else {
+			ans[i] = x.Mul(scale)
+		}
+	}
+	return ans
+}
+
+// Dropout has no parameters.
+func (d *Dropout) Parameters() []*Value {
+	return nil
+}
+
+// Switches between training and inference mode.
+func (d *Dropout) SetTraining(training bool) {
+	d.training = training
+}
+
+// Returns a copy of the module drawing from a new random source seeded by
+// the source of the module.
+func (d *Dropout) Clone() Module {
+	return &Dropout{
+		p:        d.p,
+		rng:      rand.New(rand.NewSource(d.rng.Int63())),
+		training: d.training,
+	}
+}
@@ -0,0 +1,84 @@
+package nn
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropout(t *testing.T) {
+	input := make([]*Value, 1000)
+	for i := range input {
+		input[i] = MakeValue(1.0)
+	}
+
+	dropout := MakeDropout(0.2, rand.New(rand.NewSource(1)))
+	output := dropout.Forward(input)
+	assert.Equal(t, input, output, "expected the input in inference mode")
+
+	dropout.SetTraining(true)
+	output = dropout.Forward(input)
+	sum, dropped := 0.0, 0
+	for _, y := range output {
+		if y.GetData() == 0.0 {
+			dropped++
+		} else {
+			assert.InDelta(t, 1.25, y.GetData(), 1e-12, "expected %f, got %f", 1.25, y.GetData())
+		}
+		sum += y.GetData()
+	}
+	assert.InDelta(t, 200, dropped, 30, "expected about %d dropped values, got %d", 200, dropped)
+	assert.InDelta(t, 1000.0, sum, 40.0, "expected about %f, got %f", 1000.0, sum)
+
+	// The same seed drops the same values.
+	other := MakeDropout(0.2, rand.New(rand.NewSource(1)))
+	other.SetTraining(true)
+	for i, y := range other.Forward(input) {
+		assert.Equalf(t, output[i].GetData(), y.GetData(), "expected %f, got %f", output[i].GetData(), y.GetData())
+	}
+
+	// Gradients only flow through the kept values.
+	output[0].BackPropagate()
+	expected := output[0].GetData()
+	assert.Equalf(t, expected, input[0].GetGrad(), "expected %f, got %f", expected, input[0].GetGrad())
+
+	for _, p := range []float64{-0.1, 1.0} {
+		assert.Panics(t, func() { MakeDropout(p, rand.New(rand.NewSource(1))) }, "p = %f", p)
+	}
+}
+
+// Records the mode of the network when its Forward is called.
+type modeRecorder struct {
+	training []bool
+	dropout  *Dropout
+}
+
+func (m *modeRecorder) Forward(input []*Value) []*Value {
+	m.training = append(m.training, m.dropout.training)
+	return input
+}
+
+func (m *modeRecorder) Parameters() []*Value {
+	return nil
+}
+
+func TestTrainingMode(t *testing.T) {
+	dropout := MakeDropout(0.5, rand.New(rand.NewSource(1)))
+	recorder := &modeRecorder{dropout: dropout}
+	scale := &affine{MakeValue(1.0), MakeValue(0.0)}
+	model := MakeModel(scale, MakeSequential(dropout, recorder), activation(elementwise(Sigmoid)))
+	inputs := [][]*Value{{MakeValue(-1.0)}, {MakeValue(1.0)}}
+	labels := [][]*Value{{MakeValue(0.0)}, {MakeValue(1.0)}}
+
+	assert.False(t, model.Training(), "expected inference mode by default")
+	model.Train(inputs, labels, TrainingParam{Epochs: 2, LearningRate: 0.1})
+	assert.Equal(t, []bool{true, true, true, true}, recorder.training)
+	assert.False(t, model.Training(), "expected inference mode after training")
+
+	model.SetTraining(true)
+	scores := model.Predict(inputs)
+	assert.Equal(t, []bool{true, true, true, true, false, false}, recorder.training)
+	assert.True(t, model.Training(), "expected the mode to be restored")
+	assert.InDelta(t, sigmoid(scale.a.GetData()+scale.b.GetData()), scores[1][0].GetData(), 1e-12, "expected no dropout in Predict")
+}
@@ -0,0 +1,128 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Embedding module mapping integer ids, e.g. categories or characters, to
+// trainable vectors. Each input value is an id in [0, vocabSize) and the
+// output is the concatenation of the vectors of all ids.
+//
+// The vectors looked up by forward passes in training mode are tracked, so
+// the optimizer step only updates those rows. Lookups in inference mode, e.g.
+// by Predict, are not tracked, so custom training loops switch the network to
+// training mode with SetTraining.
+type Embedding struct {
+	rows [][]*Value
+	// Guards used, since forward passes may run concurrently.
+	mu sync.Mutex
+	// Ids looked up in training mode since the last optimizer step.
+	used     map[int]bool
+	training bool
+}
+
+// Makes an embedding of vocabSize ids to vectors of size dim, initialized to
+// random numbers from the standard normal distribution drawn from rng.
+func MakeEmbedding(vocabSize, dim int, rng *rand.Rand) *Embedding {
+	rows := make([][]*Value, vocabSize)
+	for i := range rows {
+		rows[i] = make([]*Value, dim)
+		for j := range rows[i] {
+			rows[i][j] = MakeValue(rng.NormFloat64())
+		}
+	}
+	return &Embedding{
+		rows: rows,
+		used: map[int]bool{},
+	}
+}
+
+// Makes input values from integer ids.
+func MakeTokens(ids ...int) []*Value {
+	tokens := make([]*Value, len(ids))
+	for i, id := range ids {
+		tokens[i] = MakeValue(float64(id))
+	}
+	return tokens
+}
+
+// Returns the vector of an id and marks it as used in training mode.
+func (e *Embedding) Lookup(id int) []*Value {
+	if id < 0 || id >= len(e.rows) {
+		panic(fmt.Sprintf("embedding id %d out of range [0, %d)", id, len(e.rows)))
+	}
+	e.mu.Lock()
+	if e.training {
+		e.used[id] = true
+	}
+	e.mu.Unlock()
+	return e.rows[id]
+}
+
+// Returns the concatenation of the vectors of the input ids. Ids are rounded
+// to the nearest integer and no gradient flows to the input.
+func (e *Embedding) Forward(input []*Value) []*Value {
+	ans := []*Value{}
+	for _, x := range input {
+		ans = append(ans, e.Lookup(int(math.Round(x.data)))...)
+	}
+	return ans
+}
+
+// Returns the vectors of all ids in order.
+func (e *Embedding) Parameters() []*Value {
+	params := []*Value{}
+	for _, row := range e.rows {
+		params = append(params, row...)
+	}
+	return params
+}
+
+// Returns the vectors of the ids looked up since the last ClearUsed, ordered
+// by id.
+func (e *Embedding) UsedParameters() []*Value {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ids := make([]int, 0, len(e.used))
+	for id := range e.used {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	params := []*Value{}
+	for _, id := range ids {
+		params = append(params, e.rows[id]...)
+	}
+	return params
+}
+
+// Switches between tracking the looked up ids (training) and not (inference).
+func (e *Embedding) SetTraining(training bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.training = training
+}
+
+// Forgets the looked up ids.
+func (e *Embedding) ClearUsed() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.used = map[int]bool{}
+}
+
+// Returns a copy of the embedding with new vectors of the same values. No ids
+// of the copy are used.
+func (e *Embedding) Clone() Module {
+	rows := make([][]*Value, len(e.rows))
+	for i, row := range e.rows {
+		rows[i] = copyValues(row)
+	}
+	return &Embedding{
+		rows:     rows,
+		used:     map[int]bool{},
+		training: e.training,
+	}
+}
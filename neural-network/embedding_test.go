@@ -0,0 +1,75 @@
+package nn
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbedding(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	embedding := MakeEmbedding(4, 2, rng)
+	assert.Equal(t, 8, len(embedding.Parameters()), "expected %d, got %d", 8, len(embedding.Parameters()))
+
+	// Lookups are only tracked in training mode.
+	embedding.Forward(MakeTokens(1))
+	assert.Empty(t, embedding.UsedParameters())
+	embedding.SetTraining(true)
+	output := embedding.Forward(MakeTokens(2, 0, 2))
+	assert.Equal(t, 6, len(output), "expected %d, got %d", 6, len(output))
+	assert.Equal(t, embedding.rows[2], output[:2])
+	assert.Equal(t, embedding.rows[0], output[2:4])
+	assert.Equal(t, embedding.rows[2], output[4:])
+
+	weightedSum(output).BackPropagate()
+	for _, x := range embedding.rows[1] {
+		assert.Equalf(t, 0.0, x.GetGrad(), "expected %f, got %f", 0.0, x.GetGrad())
+	}
+	used := append(append([]*Value{}, embedding.rows[0]...), embedding.rows[2]...)
+	assert.Equal(t, used, embedding.UsedParameters())
+
+	embedding.ClearUsed()
+	assert.Empty(t, embedding.UsedParameters())
+	assert.Panics(t, func() { embedding.Forward(MakeTokens(4)) })
+}
+
+func TestEmbeddingSparseUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	embedding := MakeEmbedding(5, 2, rng)
+	model := MakeModel(embedding, MakeLayer(2, MakeLayerParam(1, Sigmoid), rng))
+	before := make([]float64, 0, 10)
+	for _, param := range embedding.Parameters() {
+		before = append(before, param.GetData())
+	}
+
+	inputs := [][]*Value{MakeTokens(0), MakeTokens(3)}
+	labels := [][]*Value{{MakeValue(0.0)}, {MakeValue(1.0)}}
+	// Only the used rows are regularized. Rows of the validation records are
+	// not used.
+	trainingParam := TrainingParam{
+		Epochs:           10,
+		Regularization:   0.1,
+		WeightDecay:      0.1,
+		LearningRate:     0.5,
+		Optimizer:        MakeSGD(0.9),
+		ValidationInputs: [][]*Value{MakeTokens(4)},
+		ValidationLabels: [][]*Value{{MakeValue(1.0)}},
+	}
+	losses, _, _ := model.Train(inputs, labels, trainingParam)
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+
+	for id, row := range embedding.rows {
+		for j, x := range row {
+			if id == 0 || id == 3 {
+				assert.NotEqualf(t, before[2*id+j], x.GetData(), "expected row %d to be updated", id)
+			} else {
+				assert.Equalf(t, 0.0, x.GetGrad(), "expected row %d not to have a gradient", id)
+				assert.Equalf(t, before[2*id+j], x.GetData(), "expected row %d not to be updated", id)
+			}
+		}
+	}
+	assert.Empty(t, embedding.UsedParameters())
+}
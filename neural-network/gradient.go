@@ -0,0 +1,109 @@
+package nn
+
+import (
+	"math"
+)
+
+// Clips the gradient of each value to [-limit, limit].
+func ClipValue(values []*Value, limit float64) {
+	for _, value := range values {
+		value.grad = math.Max(-limit, math.Min(limit, value.grad))
+	}
+}
+
+// Rescales the gradients of values so their global L2 norm is at most
+// maxNorm, keeping their direction. Returns the norm before clipping.
+func ClipNorm(values []*Value, maxNorm float64) float64 {
+	norm := 0.0
+	for _, value := range values {
+		norm += value.grad * value.grad
+	}
+	norm = math.Sqrt(norm)
+	if norm > maxNorm {
+		for _, value := range values {
+			value.grad *= maxNorm / norm
+		}
+	}
+	return norm
+}
+
+// Clips the gradients of the trainable parameters of the network to
+// [-limit, limit].
+func (n *NeuralNetwork) ClipValue(limit float64) {
+	ClipValue(unique(trainable(n.Parameters())), limit)
+}
+
+// Rescales the gradients of the trainable parameters of the network so their
+// global norm is at most maxNorm. Returns the norm before clipping.
+func (n *NeuralNetwork) ClipNorm(maxNorm float64) float64 {
+	return ClipNorm(unique(trainable(n.Parameters())), maxNorm)
+}
+
+// Clips the gradients of params by ClipValue and then by ClipNorm of
+// trainingParam if they are positive.
+func clipGradients(params []*Value, trainingParam TrainingParam) {
+	if trainingParam.ClipValue > 0.0 {
+		ClipValue(params, trainingParam.ClipValue)
+	}
+	if trainingParam.ClipNorm > 0.0 {
+		ClipNorm(params, trainingParam.ClipNorm)
+	}
+}
+
+// Resets the gradients and computes the gradients of the loss of the records
+// in AccumulationSteps micro-batches of consecutive records, so only the
+// graph of a micro-batch is kept in memory. The loss of each micro-batch is
+// scaled by its share of the record weights, so the accumulated gradients
+// and the loss are those of the whole batch, unless modules depend on the
+// batch, e.g. BatchNorm. Returns the scores of the records and the loss.
+func (n *NeuralNetwork) accumulateGrad(inputs, labels [][]*Value, trainingParam TrainingParam) ([][]*Value, float64) {
+	n.ResetGrad()
+	microBatches := trainingParam.AccumulationSteps
+	if microBatches > len(inputs) {
+		microBatches = len(inputs)
+	}
+	if microBatches <= 1 {
+		scores := n.Forward(inputs)
+		loss := n.Loss(labels, scores, trainingParam)
+		loss.BackPropagate()
+		return scores, loss.GetData()
+	}
+
+	// Classes are balanced over the whole batch.
+	trainingParam = balanceClasses(labels, trainingParam)
+	weights := recordWeights(labels, trainingParam)
+	weightOf := func(start, end int) float64 {
+		if weights == nil {
+			return float64(end - start)
+		}
+		sum := 0.0
+		for _, weight := range weights[start:end] {
+			sum += weight
+		}
+		return sum
+	}
+	total := weightOf(0, len(inputs))
+	if total == 0.0 {
+		panic("the weights of the records add up to zero")
+	}
+
+	scores := make([][]*Value, 0, len(inputs))
+	loss := 0.0
+	for k := 0; k < microBatches; k++ {
+		start, end := k*len(inputs)/microBatches, (k+1)*len(inputs)/microBatches
+		microScores := n.Forward(inputs[start:end])
+		scores = append(scores, microScores...)
+		share := weightOf(start, end) / total
+		if share == 0.0 {
+			continue
+		}
+		microParam := trainingParam
+		if trainingParam.SampleWeights != nil {
+			microParam.SampleWeights = trainingParam.SampleWeights[start:end]
+		}
+		microLoss := n.Loss(labels[start:end], microScores, microParam).Mul(MakeValue(share))
+		microLoss.BackPropagate()
+		loss += microLoss.GetData()
+	}
+	return scores, loss
+}
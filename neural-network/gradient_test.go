@@ -0,0 +1,110 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the grads of values.
+func grads(values []*Value) []float64 {
+	ans := make([]float64, len(values))
+	for i, value := range values {
+		ans[i] = value.GetGrad()
+	}
+	return ans
+}
+
+func TestClipGradients(t *testing.T) {
+	values := makeRecords([][]float64{{0.0, 0.0, 0.0}})[0]
+	for i, grad := range []float64{3.0, -4.0, 0.5} {
+		values[i].grad = grad
+	}
+	ClipValue(values, 1.0)
+	assert.Equal(t, []float64{1.0, -1.0, 0.5}, grads(values))
+
+	for i, grad := range []float64{3.0, -4.0, 0.0} {
+		values[i].grad = grad
+	}
+	assert.Equal(t, 5.0, ClipNorm(values, 10.0))
+	assert.Equal(t, []float64{3.0, -4.0, 0.0}, grads(values))
+	assert.Equal(t, 5.0, ClipNorm(values, 1.0))
+	assert.InDeltaSlice(t, []float64{0.6, -0.8, 0.0}, grads(values), 1e-12)
+}
+
+func TestTrainClipping(t *testing.T) {
+	inputs := makeRecords([][]float64{{10.0, 20.0}, {-10.0, 5.0}})
+	labels := makeRecords([][]float64{{0.0}, {1.0}})
+	layerParams := []LayerParam{MakeLayerParam(4, Tanh), MakeLayerParam(1, Sigmoid)}
+	for _, trainingParam := range []TrainingParam{
+		{Epochs: 1, LearningRate: 10.0, ClipValue: 0.01},
+		{Epochs: 1, LearningRate: 10.0, ClipNorm: 0.01},
+	} {
+		model := MakeNeuralNetwork(2, layerParams, rand.New(rand.NewSource(seed)))
+		before := model.GetWeights()
+		model.Train(inputs, labels, trainingParam)
+		norm := 0.0
+		for i, weight := range model.GetWeights() {
+			change := math.Abs(weight - before[i])
+			if trainingParam.ClipValue > 0.0 {
+				assert.LessOrEqual(t, change, 0.1+1e-12, "expected change <= %f, got %f", 0.1, change)
+			}
+			norm += change * change
+		}
+		if trainingParam.ClipNorm > 0.0 {
+			assert.LessOrEqual(t, math.Sqrt(norm), 0.1+1e-12, "expected norm <= %f, got %f", 0.1, math.Sqrt(norm))
+		}
+		assert.Greater(t, norm, 0.0)
+	}
+}
+
+func TestGradientAccumulation(t *testing.T) {
+	lines := ReadCSV("../data/make_moon.csv")[1:11]
+	inputs := make([][]*Value, len(lines))
+	labels := make([][]*Value, len(lines))
+	for i, line := range lines {
+		input, label := getRecord(line)
+		inputs[i], labels[i] = input, []*Value{label}
+	}
+	layerParams := []LayerParam{MakeLayerParam(4, Tanh), MakeLayerParam(1, Sigmoid)}
+	model := MakeNeuralNetwork(2, layerParams, rand.New(rand.NewSource(seed)))
+	trainingParam := TrainingParam{
+		Epochs:                  3,
+		LearningRate:            0.5,
+		Regularization:          0.01,
+		BalanceClasses:          true,
+		LabelSmoothing:          0.1,
+		SampleWeights:           []float64{1.0, 2.0, 0.5, 1.0, 0.0, 0.0, 3.0, 1.0, 1.0, 2.0},
+		ClassificationThreshold: 0.5,
+	}
+	scores, loss := model.accumulateGrad(inputs, labels, trainingParam)
+	expected := grads(model.Parameters())
+
+	// Micro-batches, including one with zero weight, have the gradients of
+	// the whole batch.
+	for _, steps := range []int{2, 3, 5, 10, 20} {
+		trainingParam.AccumulationSteps = steps
+		microScores, microLoss := model.accumulateGrad(inputs, labels, trainingParam)
+		assert.InDelta(t, loss, microLoss, 1e-12, "%d steps: expected %f, got %f", steps, loss, microLoss)
+		assert.InDeltaSlice(t, expected, grads(model.Parameters()), 1e-12, "%d steps", steps)
+		for i := range scores {
+			assert.Equal(t, scores[i][0].GetData(), microScores[i][0].GetData())
+		}
+	}
+
+	// Training with accumulated gradients is training on the whole batch.
+	clone := model.Clone()
+	trainingParam.AccumulationSteps = 4
+	losses, _, _ := model.Train(inputs, labels, trainingParam)
+	trainingParam.AccumulationSteps = 0
+	expectedLosses, _, _ := clone.Train(inputs, labels, trainingParam)
+	assert.InDeltaSlice(t, expectedLosses, losses, 1e-12)
+	assert.InDeltaSlice(t, clone.GetWeights(), model.GetWeights(), 1e-12)
+
+	// Weights adding up to zero have no mean.
+	trainingParam.AccumulationSteps = 2
+	trainingParam.SampleWeights = make([]float64, len(inputs))
+	assert.Panics(t, func() { model.accumulateGrad(inputs, labels, trainingParam) })
+}
@@ -0,0 +1,316 @@
+package nn
+
+import (
+	"fmt"
+)
+
+// A node of a Graph. Its output values are computed from the outputs of its
+// input nodes.
+type Node struct {
+	// Index of the node in the graph.
+	index  int
+	inputs []*Node
+	// Module applied on the concatenated outputs of the input nodes, or nil.
+	module Module
+	// Merges the outputs of the input nodes if module is nil.
+	merge func([][]*Value) []*Value
+}
+
+// A named input or output of a Graph.
+type port struct {
+	name string
+	node *Node
+	size int
+}
+
+// A module computing outputs from inputs through a directed acyclic graph of
+// modules, which allows skip connections, branches, multiple inputs and
+// multiple outputs. Nodes are added with Input, Apply, Add and Concat, each
+// taking nodes created before, and outputs are marked with Output.
+//
+// Forward splits a flat input into the inputs in the order they were added
+// and concatenates the outputs in the order they were marked, so a Graph can
+// be trained like any other module. ForwardNamed maps names to values.
+// A module applied in multiple nodes shares its parameters between them.
+type Graph struct {
+	nodes   []*Node
+	inputs  []port
+	outputs []port
+}
+
+// Makes an empty graph.
+func MakeGraph() *Graph {
+	return &Graph{}
+}
+
+// Returns true if the node was added to the graph.
+func (g *Graph) contains(node *Node) bool {
+	return node != nil && node.index < len(g.nodes) && g.nodes[node.index] == node
+}
+
+// Adds a node after checking that its inputs are nodes of the graph. Nodes
+// other than inputs must have at least one input.
+func (g *Graph) addNode(node *Node) *Node {
+	if (node.module != nil || node.merge != nil) && len(node.inputs) == 0 {
+		panic("graph nodes other than inputs need at least one input node")
+	}
+	for _, input := range node.inputs {
+		if !g.contains(input) {
+			panic("graph node inputs must be nodes of the same graph")
+		}
+	}
+	node.index = len(g.nodes)
+	g.nodes = append(g.nodes, node)
+	return node
+}
+
+// Adds an input of a given size. Panics if the name is already used by
+// another input.
+func (g *Graph) Input(name string, size int) *Node {
+	for _, input := range g.inputs {
+		if input.name == name {
+			panic(fmt.Sprintf("duplicate graph input %q", name))
+		}
+	}
+	node := g.addNode(&Node{})
+	g.inputs = append(g.inputs, port{name: name, node: node, size: size})
+	return node
+}
+
+// Adds a node applying a module on the concatenated outputs of the input
+// nodes.
+func (g *Graph) Apply(module Module, inputs ...*Node) *Node {
+	if module == nil {
+		panic("graph nodes cannot apply a nil module")
+	}
+	return g.addNode(&Node{inputs: inputs, module: module})
+}
+
+// Adds a node computing the element-wise sum of the outputs of the input
+// nodes, e.g. for residual connections. The outputs must have the same size.
+func (g *Graph) Add(inputs ...*Node) *Node {
+	return g.addNode(&Node{inputs: inputs, merge: func(values [][]*Value) []*Value {
+		ans := values[0]
+		for _, v := range values[1:] {
+			if len(v) != len(ans) {
+				panic(fmt.Sprintf("cannot add values of sizes %d and %d", len(ans), len(v)))
+			}
+			ans = add(ans, v)
+		}
+		return ans
+	}})
+}
+
+// Adds a node concatenating the outputs of the input nodes.
+func (g *Graph) Concat(inputs ...*Node) *Node {
+	return g.addNode(&Node{inputs: inputs, merge: func(values [][]*Value) []*Value {
+		return concat(values...)
+	}})
+}
+
+// Marks the output of a node as a named output of the graph. Panics if the
+// node isn't in the graph or the name is already used by another output.
+func (g *Graph) Output(name string, node *Node) {
+	if !g.contains(node) {
+		panic("graph outputs must be nodes of the same graph")
+	}
+	for _, output := range g.outputs {
+		if output.name == name {
+			panic(fmt.Sprintf("duplicate graph output %q", name))
+		}
+	}
+	g.outputs = append(g.outputs, port{name: name, node: node})
+}
+
+// Computes the outputs of all nodes for a batch of records where inputs maps
+// names of inputs to their values for each record.
+func (g *Graph) evaluate(inputs map[string][][]*Value, batchSize int) [][][]*Value {
+	values := make([][][]*Value, len(g.nodes))
+	for _, input := range g.inputs {
+		records, ok := inputs[input.name]
+		if !ok {
+			panic(fmt.Sprintf("missing graph input %q", input.name))
+		}
+		for _, record := range records {
+			if len(record) != input.size {
+				panic(fmt.Sprintf("graph input %q expects %d values, got %d", input.name, input.size, len(record)))
+			}
+		}
+		values[input.node.index] = records
+	}
+	for _, node := range g.nodes {
+		if len(node.inputs) == 0 {
+			continue
+		}
+		merged := make([][]*Value, batchSize)
+		for i := range merged {
+			parts := make([][]*Value, len(node.inputs))
+			for j, input := range node.inputs {
+				parts[j] = values[input.index][i]
+			}
+			if node.module != nil {
+				merged[i] = concat(parts...)
+			} else {
+				merged[i] = node.merge(parts)
+			}
+		}
+		if node.module != nil {
+			merged = forwardBatch([]Module{node.module}, merged)
+		}
+		values[node.index] = merged
+	}
+	return values
+}
+
+// Computes the named outputs of the graph given the named inputs.
+func (g *Graph) ForwardNamed(inputs map[string][]*Value) map[string][]*Value {
+	batch := make(map[string][][]*Value, len(inputs))
+	for name, input := range inputs {
+		batch[name] = [][]*Value{input}
+	}
+	values := g.evaluate(batch, 1)
+	ans := make(map[string][]*Value, len(g.outputs))
+	for _, output := range g.outputs {
+		ans[output.name] = values[output.node.index][0]
+	}
+	return ans
+}
+
+// Computes the concatenated outputs given the concatenated inputs.
+func (g *Graph) Forward(input []*Value) []*Value {
+	return g.ForwardBatch([][]*Value{input})[0]
+}
+
+// Computes the concatenated outputs of each record given its concatenated
+// inputs. Modules implementing BatchModule see all records at once.
+func (g *Graph) ForwardBatch(inputs [][]*Value) [][]*Value {
+	size := 0
+	for _, input := range g.inputs {
+		size += input.size
+	}
+	batch := make(map[string][][]*Value, len(g.inputs))
+	for _, record := range inputs {
+		if len(record) != size {
+			panic(fmt.Sprintf("graph expects %d input values, got %d", size, len(record)))
+		}
+		start := 0
+		for _, input := range g.inputs {
+			batch[input.name] = append(batch[input.name], record[start:start+input.size])
+			start += input.size
+		}
+	}
+	values := g.evaluate(batch, len(inputs))
+	ans := make([][]*Value, len(inputs))
+	for i := range ans {
+		for _, output := range g.outputs {
+			ans[i] = append(ans[i], values[output.node.index][i]...)
+		}
+	}
+	return ans
+}
+
+// Returns the modules of all nodes in order.
+func (g *Graph) modules() []Module {
+	modules := []Module{}
+	for _, node := range g.nodes {
+		if node.module != nil {
+			modules = append(modules, node.module)
+		}
+	}
+	return modules
+}
+
+// Returns values once each, keeping the first occurrence.
+func unique(values []*Value) []*Value {
+	seen := map[*Value]bool{}
+	ans := []*Value{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			ans = append(ans, value)
+		}
+	}
+	return ans
+}
+
+// Returns the parameters of all modules, each shared parameter once.
+func (g *Graph) Parameters() []*Value {
+	return unique(parameters(g.modules()))
+}
+
+// Returns the neurons of all modules, each shared neuron once.
+func (g *Graph) Neurons() []*Neuron {
+	seen := map[*Neuron]bool{}
+	ans := []*Neuron{}
+	for _, neuron := range neurons(g.modules()) {
+		if !seen[neuron] {
+			seen[neuron] = true
+			ans = append(ans, neuron)
+		}
+	}
+	return ans
+}
+
+// Switches the mode of all modules which support it.
+func (g *Graph) SetTraining(training bool) {
+	setTraining(g.modules(), training)
+}
+
+// Returns the state of all modules implementing Stateful.
+func (g *Graph) State() []*Value {
+	return unique(MakeSequential(g.modules()...).State())
+}
+
+// Returns the parameters of all modules, only including the used parameters
+// of modules implementing SparseModule.
+func (g *Graph) UsedParameters() []*Value {
+	return unique(usedParameters(g.modules()))
+}
+
+// Forgets the used parameters of all modules implementing SparseModule.
+func (g *Graph) ClearUsed() {
+	clearUsed(g.modules())
+}
+
+// Updates the running statistics of all modules implementing
+// StatisticsModule.
+func (g *Graph) UpdateStatistics() {
+	updateStatistics(g.modules())
+}
+
+// Forgets the collected statistics of all modules implementing
+// StatisticsModule.
+func (g *Graph) DiscardStatistics() {
+	discardStatistics(g.modules())
+}
+
+// Returns a copy of the graph with copies of its modules. A module applied in
+// multiple nodes is copied once and shared by the nodes of the copy.
+func (g *Graph) Clone() Module {
+	clones := map[Module]Module{}
+	ans := &Graph{
+		nodes:   make([]*Node, len(g.nodes)),
+		inputs:  make([]port, len(g.inputs)),
+		outputs: make([]port, len(g.outputs)),
+	}
+	for i, node := range g.nodes {
+		clone := &Node{index: node.index, module: node.module, merge: node.merge}
+		for _, input := range node.inputs {
+			clone.inputs = append(clone.inputs, ans.nodes[input.index])
+		}
+		if node.module != nil {
+			if _, ok := clones[node.module]; !ok {
+				clones[node.module] = cloneModule(node.module)
+			}
+			clone.module = clones[node.module]
+		}
+		ans.nodes[i] = clone
+	}
+	for i, input := range g.inputs {
+		ans.inputs[i] = port{name: input.name, node: ans.nodes[input.node.index], size: input.size}
+	}
+	for i, output := range g.outputs {
+		ans.outputs[i] = port{name: output.name, node: ans.nodes[output.node.index], size: output.size}
+	}
+	return ans
+}
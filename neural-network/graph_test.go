@@ -0,0 +1,104 @@
+package nn
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraph(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	graph := MakeGraph()
+	x := graph.Input("x", 2)
+	y := graph.Input("y", 1)
+	hidden := MakeLayer(3, MakeLayerParam(2, Tanh), rng)
+	h := graph.Apply(hidden, x, y)
+	// Residual connection.
+	r := graph.Add(x, h)
+	// The same layer applied twice shares its parameters.
+	s := graph.Apply(hidden, r, y)
+	graph.Output("sum", graph.Concat(r, s))
+	graph.Output("score", graph.Apply(MakeLayer(2, MakeLayerParam(1, Sigmoid), rng), s))
+	assert.Equal(t, 2*4+3, len(graph.Parameters()), "expected %d, got %d", 2*4+3, len(graph.Parameters()))
+
+	xs, ys := makeInput(2), []*Value{MakeValue(0.5)}
+	outputs := graph.ForwardNamed(map[string][]*Value{"x": xs, "y": ys})
+	assert.Equal(t, 4, len(outputs["sum"]), "expected %d, got %d", 4, len(outputs["sum"]))
+	assert.Equal(t, 1, len(outputs["score"]), "expected %d, got %d", 1, len(outputs["score"]))
+	hs := hidden.Fit(concat(xs, ys))
+	for i := range xs {
+		expected := xs[i].GetData() + hs[i].GetData()
+		assert.InDelta(t, expected, outputs["sum"][i].GetData(), 1e-12, "expected %f, got %f", expected, outputs["sum"][i].GetData())
+	}
+
+	output := graph.Forward(concat(xs, ys))
+	expected := append(append([]*Value{}, outputs["sum"]...), outputs["score"]...)
+	assert.Equal(t, len(expected), len(output), "expected %d, got %d", len(expected), len(output))
+	for i := range output {
+		assert.InDelta(t, expected[i].GetData(), output[i].GetData(), 1e-12, "expected %f, got %f", expected[i].GetData(), output[i].GetData())
+	}
+
+	checkGradient(t, append(graph.Parameters(), xs...), func() *Value {
+		return weightedSum(graph.Forward(concat(xs, ys)))
+	})
+
+	assert.Panics(t, func() { graph.ForwardNamed(map[string][]*Value{"x": xs}) })
+	assert.Panics(t, func() { graph.Forward(xs) })
+	assert.Panics(t, func() { MakeGraph().Add(x) })
+}
+
+func TestGraphValidation(t *testing.T) {
+	other := MakeGraph()
+	foreign := other.Input("x", 1)
+	for name, build := range map[string]func(*Graph){
+		"duplicate input": func(g *Graph) {
+			g.Input("x", 1)
+			g.Input("x", 2)
+		},
+		"duplicate output": func(g *Graph) {
+			x := g.Input("x", 1)
+			g.Output("y", x)
+			g.Output("y", x)
+		},
+		"foreign output": func(g *Graph) { g.Output("y", foreign) },
+		"nil output":     func(g *Graph) { g.Output("y", nil) },
+		"empty add":      func(g *Graph) { g.Add() },
+		"empty concat":   func(g *Graph) { g.Concat() },
+		"empty apply":    func(g *Graph) { g.Apply(MakeFlatten()) },
+		"nil module":     func(g *Graph) { g.Apply(nil, g.Input("x", 1)) },
+		"foreign input":  func(g *Graph) { g.Apply(MakeFlatten(), foreign) },
+	} {
+		assert.Panics(t, func() { build(MakeGraph()) }, name)
+	}
+	// The same node may be marked as outputs with different names.
+	assert.NotPanics(t, func() {
+		g := MakeGraph()
+		x := g.Input("x", 1)
+		g.Output("a", x)
+		g.Output("b", x)
+	})
+}
+
+func TestGraphTrain(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	// Two branches of the same input with batch normalization on one of them.
+	graph := MakeGraph()
+	x := graph.Input("x", 2)
+	bn := MakeBatchNorm(2, 0.5)
+	left := graph.Apply(MakeLayer(2, MakeLayerParam(3, Tanh), rng), graph.Apply(bn, x))
+	right := graph.Apply(MakeLayer(2, MakeLayerParam(3, Relu), rng), x)
+	graph.Output("score", graph.Apply(MakeLayer(6, MakeLayerParam(1, Sigmoid), rng), graph.Concat(left, right)))
+
+	model := MakeModel(graph)
+	inputs := makeRecords([][]float64{{1.0, 2.0}, {-1.0, 0.5}, {2.0, -1.0}, {-2.0, -2.0}})
+	labels := makeRecords([][]float64{{1.0}, {0.0}, {1.0}, {0.0}})
+	losses, _, _ := model.Train(inputs, labels, TrainingParam{Epochs: 20, LearningRate: 0.5})
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+
+	// Batch statistics are tracked through the graph.
+	assert.NotEqual(t, 0.0, bn.mean[1].GetData(), "expected running mean to be updated")
+	assert.Equal(t, bn.State(), graph.State())
+}
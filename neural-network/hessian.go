@@ -0,0 +1,168 @@
+package nn
+
+import (
+	"math"
+)
+
+// Hessian-free (truncated Newton) optimizer. Every step approximately solves
+// (H + damping*I) d = -g with conjugate gradient, where H is the Hessian and g
+// is the gradient of the loss, using only Hessian-vector products.
+// The damping is adapted after every step depending on how well the quadratic
+// model predicted the change of the loss, so a HessianFree object should be
+// reused between steps.
+//
+// A step evaluates the loss many times and assumes it's deterministic, so it
+// doesn't work with modules drawing random numbers in training mode, e.g.
+// Dropout, whose masks would make the Hessian-vector products noise.
+type HessianFree struct {
+	// Maximum number of conjugate gradient iterations per step.
+	CGIterations int
+	// Damping added to the diagonal of the Hessian.
+	Damping float64
+}
+
+// Makes a Hessian-free optimizer with reasonable defaults for small models.
+func MakeHessianFree() *HessianFree {
+	return &HessianFree{
+		CGIterations: 10,
+		Damping:      1.0,
+	}
+}
+
+// Computes the gradient of the loss with respect to params. loss builds the
+// loss graph using the current data of params. Grad of params is not touched.
+func Gradient(params []*Value, loss func() *Value) []float64 {
+	grads := loss().Backward()
+	ans := make([]float64, len(params))
+	for i, param := range params {
+		ans[i] = grads[param]
+	}
+	return ans
+}
+
+// Computes the product of the Hessian of the loss with respect to params and
+// a vector v, using central finite differences of gradients:
+//
+//	Hv = (g(w + eps*v) - g(w - eps*v)) / (2*eps)
+//
+// Data of params is restored before returning.
+func HessianVectorProduct(params []*Value, v []float64, loss func() *Value) []float64 {
+	ans := make([]float64, len(params))
+	norm := math.Sqrt(dot(v, v))
+	if norm == 0.0 {
+		return ans
+	}
+	eps := 1e-4 / norm
+
+	data := make([]float64, len(params))
+	for i, param := range params {
+		data[i] = param.data
+	}
+	shift(params, v, eps)
+	pos := Gradient(params, loss)
+	shift(params, v, -2*eps)
+	neg := Gradient(params, loss)
+	for i, param := range params {
+		param.data = data[i]
+	}
+
+	for i := range ans {
+		ans[i] = (pos[i] - neg[i]) / (2 * eps)
+	}
+	return ans
+}
+
+// Updates params in place by one Hessian-free step and returns the loss after
+// the step. before is the loss at the current params, e.g. computed by the
+// caller along with the scores, and loss re-evaluates it. Steps that increase
+// the loss are rejected.
+func (h *HessianFree) Step(params []*Value, before *Value, loss func() *Value) float64 {
+	grads := before.Backward()
+	g := make([]float64, len(params))
+	for i, param := range params {
+		g[i] = grads[param]
+	}
+
+	hvp := func(v []float64) []float64 {
+		ans := HessianVectorProduct(params, v, loss)
+		for i := range ans {
+			ans[i] += h.Damping * v[i]
+		}
+		return ans
+	}
+	d, curvature := h.conjugateGradient(hvp, g)
+
+	shift(params, d, 1.0)
+	after := loss().GetData()
+	// The decrease of the loss predicted by the quadratic model.
+	predicted := dot(g, d) + 0.5*curvature
+	if after > before.GetData() || math.IsNaN(after) {
+		shift(params, d, -1.0)
+		h.Damping *= 1.5
+		return before.GetData()
+	}
+	if predicted < 0.0 {
+		// Levenberg-Marquardt heuristic.
+		rho := (after - before.GetData()) / predicted
+		if rho < 0.25 {
+			h.Damping *= 1.5
+		} else if rho > 0.75 {
+			h.Damping *= 2.0 / 3.0
+		}
+	}
+	return after
+}
+
+// Approximately solves A d = -g where A v = hvp(v), starting from d = 0.
+// Stops early on negative curvature. Returns d and d^T A d.
+func (h *HessianFree) conjugateGradient(hvp func([]float64) []float64, g []float64) ([]float64, float64) {
+	d := make([]float64, len(g))
+	r := make([]float64, len(g))
+	for i := range r {
+		r[i] = -g[i]
+	}
+	p := append([]float64{}, r...)
+	rr := dot(r, r)
+	tol := 1e-10 * rr
+	for k := 0; k < h.CGIterations && rr > tol; k++ {
+		ap := hvp(p)
+		pap := dot(p, ap)
+		if pap <= 0.0 {
+			if k == 0 {
+				// Falls back to a gradient descent step with learning rate
+				// 1/damping.
+				for i := range d {
+					d[i] = r[i] / h.Damping
+				}
+				return d, h.Damping * dot(d, d)
+			}
+			break
+		}
+		alpha := rr / pap
+		for i := range d {
+			d[i] += alpha * p[i]
+			r[i] -= alpha * ap[i]
+		}
+		next := dot(r, r)
+		for i := range p {
+			p[i] = r[i] + next/rr*p[i]
+		}
+		rr = next
+	}
+	// Since r = -g - A d, d^T A d = -d^T g - d^T r.
+	return d, -dot(d, g) - dot(d, r)
+}
+
+// Moves params data by scale*v.
+func shift(params []*Value, v []float64, scale float64) {
+	for i, param := range params {
+		param.data += scale * v[i]
+	}
+}
+
+func dot(a, b []float64) (ans float64) {
+	for i := range a {
+		ans += a[i] * b[i]
+	}
+	return ans
+}
@@ -0,0 +1,47 @@
+package nn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHessianVectorProduct(t *testing.T) {
+	x, y := MakeValue(1.0), MakeValue(2.0)
+	// f = x^2*y + y^3
+	loss := func() *Value {
+		return x.Mul(x).Mul(y).Add(y.Pow(3.0))
+	}
+	params := []*Value{x, y}
+
+	grad := Gradient(params, loss)
+	assert.InDelta(t, 4.0, grad[0], delta, "expected %f, got %f", 4.0, grad[0])
+	assert.InDelta(t, 13.0, grad[1], delta, "expected %f, got %f", 13.0, grad[1])
+
+	// H = [[2y, 2x], [2x, 6y]]
+	hv := HessianVectorProduct(params, []float64{1.0, 1.0}, loss)
+	assert.InDelta(t, 6.0, hv[0], delta, "expected %f, got %f", 6.0, hv[0])
+	assert.InDelta(t, 14.0, hv[1], delta, "expected %f, got %f", 14.0, hv[1])
+
+	// Data of params is restored.
+	assert.Equalf(t, 1.0, x.GetData(), "expected %f, got %f", 1.0, x.GetData())
+	assert.Equalf(t, 2.0, y.GetData(), "expected %f, got %f", 2.0, y.GetData())
+}
+
+func TestHessianFreeStep(t *testing.T) {
+	x := MakeValue(3.0)
+	// f = (x-1)^2 has its minimum at 1.
+	evaluations := 0
+	loss := func() *Value {
+		evaluations++
+		return x.Sub(MakeValue(1.0)).Pow(2.0)
+	}
+	hf := &HessianFree{CGIterations: 1, Damping: 1e-6}
+	before := x.Sub(MakeValue(1.0)).Pow(2.0)
+	after := hf.Step([]*Value{x}, before, loss)
+	assert.InDelta(t, 0.0, after, 1e-6, "expected %f, got %f", 0.0, after)
+	assert.InDelta(t, 1.0, x.GetData(), 1e-4, "expected %f, got %f", 1.0, x.GetData())
+	// The given loss is reused: two evaluations for the Hessian-vector
+	// product and one after the step.
+	assert.Equal(t, 3, evaluations, "expected %d, got %d", 3, evaluations)
+}
@@ -0,0 +1,122 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+)
+
+// An Initializer returns the initial weights of a layer of fanOut neurons
+// with fanIn inputs each, as fanOut rows of fanIn values drawn from rng.
+type Initializer func(fanIn, fanOut int, rng *rand.Rand) [][]float64
+
+// Returns an initializer drawing each value independently by draw.
+func makeInitializer(draw func(fanIn, fanOut int, rng *rand.Rand) float64) Initializer {
+	return func(fanIn, fanOut int, rng *rand.Rand) [][]float64 {
+		ans := make([][]float64, fanOut)
+		for i := range ans {
+			ans[i] = make([]float64, fanIn)
+			for j := range ans[i] {
+				ans[i][j] = draw(fanIn, fanOut, rng)
+			}
+		}
+		return ans
+	}
+}
+
+// Initializes all values to c.
+func Constant(c float64) Initializer {
+	return makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+		return c
+	})
+}
+
+// Initializes all values to zero.
+var Zeros = Constant(0.0)
+
+// Draws values from the normal distribution with mean 0 and a given standard
+// deviation.
+func Normal(std float64) Initializer {
+	return makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+		return std * rng.NormFloat64()
+	})
+}
+
+// Draws values from the uniform distribution over [-limit, limit).
+func Uniform(limit float64) Initializer {
+	return makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+		return limit * (2*rng.Float64() - 1)
+	})
+}
+
+// Xavier (Glorot) uniform initializer: U(-a, a) with a = sqrt(6/(fanIn+fanOut))
+var XavierUniform = makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+	return math.Sqrt(6.0/float64(fanIn+fanOut)) * (2*rng.Float64() - 1)
+})
+
+// Xavier (Glorot) normal initializer: N(0, 2/(fanIn+fanOut))
+var XavierNormal = makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+	return math.Sqrt(2.0/float64(fanIn+fanOut)) * rng.NormFloat64()
+})
+
+// He (Kaiming) uniform initializer for ReLU layers: U(-a, a) with
+// a = sqrt(6/fanIn)
+var HeUniform = makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+	return math.Sqrt(6.0/float64(fanIn)) * (2*rng.Float64() - 1)
+})
+
+// He (Kaiming) normal initializer for ReLU layers: N(0, 2/fanIn)
+var HeNormal = makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+	return math.Sqrt(2.0/float64(fanIn)) * rng.NormFloat64()
+})
+
+// LeCun uniform initializer for SELU layers: U(-a, a) with a = sqrt(3/fanIn)
+var LecunUniform = makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+	return math.Sqrt(3.0/float64(fanIn)) * (2*rng.Float64() - 1)
+})
+
+// LeCun normal initializer for SELU layers: N(0, 1/fanIn)
+var LecunNormal = makeInitializer(func(fanIn, fanOut int, rng *rand.Rand) float64 {
+	return math.Sqrt(1.0/float64(fanIn)) * rng.NormFloat64()
+})
+
+// Orthogonal initializer: the rows of the weights are orthonormal if
+// fanOut <= fanIn, and the columns are orthonormal otherwise. The weights are
+// scaled by gain.
+func Orthogonal(gain float64) Initializer {
+	return func(fanIn, fanOut int, rng *rand.Rand) [][]float64 {
+		rows, cols := fanOut, fanIn
+		if rows > cols {
+			rows, cols = cols, rows
+		}
+		// Orthonormalizes random vectors by the Gram-Schmidt process.
+		vectors := Normal(1.0)(cols, rows, rng)
+		for i, v := range vectors {
+			// Orthogonalizing twice keeps the vectors orthogonal in floating
+			// point arithmetic.
+			for pass := 0; pass < 2; pass++ {
+				for _, u := range vectors[:i] {
+					d := dot(u, v)
+					for k := range v {
+						v[k] -= d * u[k]
+					}
+				}
+			}
+			norm := math.Sqrt(dot(v, v))
+			for k := range v {
+				v[k] /= norm
+			}
+		}
+		ans := make([][]float64, fanOut)
+		for i := range ans {
+			ans[i] = make([]float64, fanIn)
+			for j := range ans[i] {
+				if fanOut <= fanIn {
+					ans[i][j] = gain * vectors[i][j]
+				} else {
+					ans[i][j] = gain * vectors[j][i]
+				}
+			}
+		}
+		return ans
+	}
+}
@@ -0,0 +1,115 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the mean and variance of all values.
+func moments(values [][]float64) (float64, float64) {
+	n, sum, squares := 0.0, 0.0, 0.0
+	for _, row := range values {
+		for _, x := range row {
+			n++
+			sum += x
+			squares += x * x
+		}
+	}
+	mean := sum / n
+	return mean, squares/n - mean*mean
+}
+
+func TestInitializers(t *testing.T) {
+	fanIn, fanOut := 200, 100
+	initializers := map[string]struct {
+		init     Initializer
+		variance float64
+		limit    float64
+	}{
+		"XavierUniform": {XavierUniform, 2.0 / 300, math.Sqrt(6.0 / 300)},
+		"XavierNormal":  {XavierNormal, 2.0 / 300, math.Inf(1)},
+		"HeUniform":     {HeUniform, 2.0 / 200, math.Sqrt(6.0 / 200)},
+		"HeNormal":      {HeNormal, 2.0 / 200, math.Inf(1)},
+		"LecunUniform":  {LecunUniform, 1.0 / 200, math.Sqrt(3.0 / 200)},
+		"LecunNormal":   {LecunNormal, 1.0 / 200, math.Inf(1)},
+		"Normal":        {Normal(0.5), 0.25, math.Inf(1)},
+		"Uniform":       {Uniform(0.5), 0.25 / 3, 0.5},
+	}
+	for name, test := range initializers {
+		values := test.init(fanIn, fanOut, rand.New(rand.NewSource(seed)))
+		assert.Equal(t, fanOut, len(values), "%s: expected %d rows, got %d", name, fanOut, len(values))
+		for _, row := range values {
+			assert.Equal(t, fanIn, len(row), "%s: expected %d columns, got %d", name, fanIn, len(row))
+			for _, x := range row {
+				assert.LessOrEqual(t, math.Abs(x), test.limit, "%s: %f is out of bounds", name, x)
+			}
+		}
+		mean, variance := moments(values)
+		assert.InDelta(t, 0.0, mean, 0.1*math.Sqrt(test.variance), "%s: expected mean %f, got %f", name, 0.0, mean)
+		assert.InEpsilon(t, test.variance, variance, 0.05, "%s: expected variance %f, got %f", name, test.variance, variance)
+
+		// The same seed draws the same values.
+		assert.Equal(t, values, test.init(fanIn, fanOut, rand.New(rand.NewSource(seed))), name)
+	}
+
+	assert.Equal(t, [][]float64{{0.0, 0.0}}, Zeros(2, 1, nil))
+	assert.Equal(t, [][]float64{{0.1}, {0.1}}, Constant(0.1)(1, 2, nil))
+}
+
+func TestOrthogonal(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	for _, shape := range [][2]int{{5, 3}, {3, 5}, {4, 4}} {
+		fanIn, fanOut := shape[0], shape[1]
+		values := Orthogonal(2.0)(fanIn, fanOut, rng)
+		assert.Equal(t, fanOut, len(values), "expected %d rows, got %d", fanOut, len(values))
+		// Columns of a matrix with more rows than columns are orthogonal.
+		vectors := values
+		if fanOut > fanIn {
+			vectors = make([][]float64, fanIn)
+			for j := range vectors {
+				for _, row := range values {
+					vectors[j] = append(vectors[j], row[j])
+				}
+			}
+		}
+		for i := range vectors {
+			for j := range vectors {
+				expected := 0.0
+				if i == j {
+					expected = 4.0
+				}
+				actual := dot(vectors[i], vectors[j])
+				assert.InDelta(t, expected, actual, 1e-12, "expected %f, got %f", expected, actual)
+			}
+		}
+	}
+}
+
+func TestLayerInitializers(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layerParam := MakeLayerParam(3, Relu).WithInitializers(Constant(0.5), Zeros)
+	params := MakeLayer(2, layerParam, rng).Parameters()
+	expected := []float64{0.0, 0.5, 0.5, 0.0, 0.5, 0.5, 0.0, 0.5, 0.5}
+	for i, param := range params {
+		assert.Equal(t, expected[i], param.GetData(), "expected %f, got %f", expected[i], param.GetData())
+	}
+	// Intercepts are zeros if only the weights have an initializer.
+	params = MakeLayer(2, MakeLayerParam(3, Relu).WithInitializers(Constant(0.5), nil), rng).Parameters()
+	for i, param := range params {
+		assert.Equal(t, expected[i], param.GetData(), "expected %f, got %f", expected[i], param.GetData())
+	}
+
+	// Networks made with the same seed have the same parameters.
+	layerParams := []LayerParam{
+		MakeLayerParam(4, Relu).WithInitializers(HeNormal, Zeros),
+		MakeLayerParam(1, Sigmoid).WithInitializers(XavierUniform, nil),
+	}
+	first := MakeNeuralNetwork(2, layerParams, rand.New(rand.NewSource(seed))).Parameters()
+	second := MakeNeuralNetwork(2, layerParams, rand.New(rand.NewSource(seed))).Parameters()
+	for i := range first {
+		assert.Equal(t, first[i].GetData(), second[i].GetData(), "expected %f, got %f", first[i].GetData(), second[i].GetData())
+	}
+}
@@ -2,6 +2,7 @@ package nn
 
 import (
 	"encoding/csv"
+	"fmt"
 	"log"
 	"math"
 	"math/rand"
@@ -32,18 +33,112 @@ func ReadCSV(filename string) [][]string {
 // the number of records, we randomly sample from it.
 // The input and label sizes are equal to the batchSize.
 func GetRecords(lines [][]string, batchSize int) ([][]*Value, [][]*Value) {
+	inputs, labels, _ := getRecords(lines, batchSize, -1)
+	return inputs, labels
+}
+
+// Returns the inputs, labels and sample weights of the lines like
+// GetRecords, where the sample weight of each record is read from a given
+// column which is not part of the input. The weights can be used as
+// TrainingParam.SampleWeights. Panics with the line number if the column is
+// missing or isn't a number.
+func GetWeightedRecords(lines [][]string, batchSize, weightColumn int) ([][]*Value, [][]*Value, []float64) {
+	return getRecords(lines, batchSize, weightColumn)
+}
+
+// Returns the records of a random batch of the lines, reading sample weights
+// from weightColumn unless it is negative.
+func getRecords(lines [][]string, batchSize, weightColumn int) ([][]*Value, [][]*Value, []float64) {
 	numRecords := len(lines)
 	batchIndices := getBatchIndices(batchSize, numRecords, time.Now().Unix())
 
 	inputs := make([][]*Value, 0, batchSize)
 	labels := make([][]*Value, 0, batchSize)
+	var weights []float64
 
 	for _, i := range batchIndices {
-		input, label := getRecord(lines[i])
+		line := lines[i]
+		if weightColumn >= 0 {
+			if weightColumn >= len(line) {
+				panic(fmt.Sprintf("line %d: weight column %d out of range of %d columns", i+1, weightColumn, len(line)))
+			}
+			weight, err := strconv.ParseFloat(line[weightColumn], 64)
+			if err != nil {
+				panic(fmt.Sprintf("line %d, column %d: invalid sample weight: %v", i+1, weightColumn, err))
+			}
+			weights = append(weights, weight)
+			line = append(append([]string{}, line[:weightColumn]...), line[weightColumn+1:]...)
+		}
+		input, label := getRecord(line)
 		inputs = append(inputs, input)
 		labels = append(labels, []*Value{label})
 	}
-	return inputs, labels
+	return inputs, labels, weights
+}
+
+// Saves the parameters of the network to a CSV file, one line per module in
+// the order returned by its Parameters method, followed by the state of
+// modules implementing Stateful.
+func (n *NeuralNetwork) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	for _, module := range n.modules {
+		params := persisted(module)
+		line := make([]string, len(params))
+		for i, param := range params {
+			line[i] = strconv.FormatFloat(param.data, 'g', -1, 64)
+		}
+		if err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Loads the parameters of the network from a CSV file written by Save. The
+// network must have the same architecture as the saved one.
+func (n *NeuralNetwork) Load(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// Modules have different number of parameters.
+	reader.FieldsPerRecord = -1
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(lines) != len(n.modules) {
+		return fmt.Errorf("expected %d modules, got %d", len(n.modules), len(lines))
+	}
+	data := make([][]float64, len(lines))
+	for i, line := range lines {
+		if params := persisted(n.modules[i]); len(line) != len(params) {
+			return fmt.Errorf("module %d: expected %d values, got %d", i, len(params), len(line))
+		}
+		data[i] = make([]float64, len(line))
+		for j, field := range line {
+			if data[i][j], err = strconv.ParseFloat(field, 64); err != nil {
+				return fmt.Errorf("module %d: %v", i, err)
+			}
+		}
+	}
+	// Parameters are only updated when the whole file is valid.
+	for i, module := range n.modules {
+		for j, param := range persisted(module) {
+			param.data = data[i][j]
+		}
+	}
+	return nil
 }
 
 // Plotter object containing parameters for plotting a graph.
@@ -0,0 +1,254 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+)
+
+// A loss function computes the loss of a record given its labels and the
+// scores of the network. The loss of a batch is the mean of the losses of its
+// records.
+type LossFunc interface {
+	Loss(labels, scores []*Value) *Value
+}
+
+// Adapts a function to the LossFunc interface.
+type lossFunc func(labels, scores []*Value) *Value
+
+func (f lossFunc) Loss(labels, scores []*Value) *Value {
+	return f(labels, scores)
+}
+
+// Adapts a function computing a classification loss whose labels are
+// probabilities to the LossFunc interface. Only labels of classification
+// losses are smoothed by TrainingParam.LabelSmoothing.
+type classificationLoss func(labels, scores []*Value) *Value
+
+func (f classificationLoss) Loss(labels, scores []*Value) *Value {
+	return f(labels, scores)
+}
+
+// Probabilities are clipped to at least lossEpsilon before taking their
+// logarithms, so losses are finite.
+const lossEpsilon = 1e-12
+
+// Logarithm of x clipped to lossEpsilon. The gradient is 0 where x is
+// clipped.
+var clippedLog = MakeActivation("Log",
+	func(x float64) float64 {
+		return math.Log(math.Max(x, lossEpsilon))
+	},
+	func(x float64) float64 {
+		if x < lossEpsilon {
+			return 0.0
+		}
+		return 1.0 / x
+	},
+)
+
+// Probability x clipped to [lossEpsilon, 1]. The gradient is 0 where x is
+// clipped.
+var clippedProbability = MakeActivation("Clip",
+	func(x float64) float64 {
+		return math.Max(lossEpsilon, math.Min(1.0, x))
+	},
+	func(x float64) float64 {
+		if x < lossEpsilon || x > 1.0 {
+			return 0.0
+		}
+		return 1.0
+	},
+)
+
+// Absolute value: |x|
+var abs = MakeActivation("Abs", math.Abs, func(x float64) float64 {
+	if x < 0.0 {
+		return -1.0
+	}
+	return 1.0
+})
+
+// Returns the sum of f(label, score) over the outputs of a record.
+func sumLoss(labels, scores []*Value, f func(label, score *Value) *Value) *Value {
+	ans := MakeValue(0.0)
+	for i, score := range scores {
+		ans = ans.Add(f(labels[i], score))
+	}
+	return ans
+}
+
+// Returns the mean of f(label, score) over the outputs of a record.
+func meanLoss(labels, scores []*Value, f func(label, score *Value) *Value) *Value {
+	return sumLoss(labels, scores, f).Div(MakeValue(float64(len(scores))))
+}
+
+// Mean squared error: mean((y_i - s_i)^2)
+var MeanSquaredError LossFunc = lossFunc(func(labels, scores []*Value) *Value {
+	return meanLoss(labels, scores, func(label, score *Value) *Value {
+		return score.Sub(label).Pow(2)
+	})
+})
+
+// Mean absolute error: mean(|y_i - s_i|)
+var MeanAbsoluteError LossFunc = lossFunc(func(labels, scores []*Value) *Value {
+	return meanLoss(labels, scores, func(label, score *Value) *Value {
+		return abs(score.Sub(label))
+	})
+})
+
+// Huber loss: the mean of d^2/2 if |d| <= delta else delta*(|d| - delta/2)
+// where d = s_i - y_i. It is quadratic for small errors and linear for large
+// errors, so it is less sensitive to outliers than MeanSquaredError.
+func Huber(delta float64) LossFunc {
+	huber := MakeActivation("Huber",
+		func(d float64) float64 {
+			if math.Abs(d) <= delta {
+				return 0.5 * d * d
+			}
+			return delta * (math.Abs(d) - 0.5*delta)
+		},
+		func(d float64) float64 {
+			return math.Max(-delta, math.Min(delta, d))
+		},
+	)
+	return lossFunc(func(labels, scores []*Value) *Value {
+		return meanLoss(labels, scores, func(label, score *Value) *Value {
+			return huber(score.Sub(label))
+		})
+	})
+}
+
+// Returns a label in {0, 1} as -1 or 1, and other labels unchanged.
+func signedLabel(label *Value) *Value {
+	if label.data == 0.0 {
+		return MakeValue(-1.0)
+	}
+	return label
+}
+
+// Hinge loss: mean(max(0, 1 - y_i*s_i)) where labels are -1 or 1 (0 is
+// treated as -1) and scores are raw outputs, e.g. of a layer without
+// activation.
+var Hinge LossFunc = lossFunc(func(labels, scores []*Value) *Value {
+	return meanLoss(labels, scores, func(label, score *Value) *Value {
+		return Relu(MakeValue(1.0).Sub(signedLabel(label).Mul(score)))
+	})
+})
+
+// Squared hinge loss: mean(max(0, 1 - y_i*s_i)^2) with labels and scores as
+// in Hinge.
+var SquaredHinge LossFunc = lossFunc(func(labels, scores []*Value) *Value {
+	return meanLoss(labels, scores, func(label, score *Value) *Value {
+		return Relu(MakeValue(1.0).Sub(signedLabel(label).Mul(score))).Pow(2)
+	})
+})
+
+// Binary cross-entropy: mean(-(y_i*log(p_i) + (1-y_i)*log(1-p_i))) where
+// scores are probabilities, e.g. outputs of Sigmoid, and labels are in [0, 1].
+var BinaryCrossEntropy LossFunc = classificationLoss(func(labels, scores []*Value) *Value {
+	return meanLoss(labels, scores, func(label, score *Value) *Value {
+		pos := label.Mul(clippedLog(score))
+		neg := MakeValue(1.0).Sub(label).Mul(clippedLog(MakeValue(1.0).Sub(score)))
+		return pos.Add(neg).Mul(MakeValue(-1.0))
+	})
+})
+
+// Categorical cross-entropy: -sum(y_i*log(p_i)) where scores are
+// probabilities, e.g. outputs of Softmax, and labels are one-hot or a
+// distribution.
+var CategoricalCrossEntropy LossFunc = classificationLoss(func(labels, scores []*Value) *Value {
+	return sumLoss(labels, scores, func(label, score *Value) *Value {
+		return label.Mul(clippedLog(score)).Mul(MakeValue(-1.0))
+	})
+})
+
+// Kullback-Leibler divergence of the scores from the labels:
+// sum(y_i*log(y_i/p_i)) where both are distributions. Terms with y_i = 0 are
+// 0.
+var KLDivergence LossFunc = classificationLoss(func(labels, scores []*Value) *Value {
+	return sumLoss(labels, scores, func(label, score *Value) *Value {
+		return label.Mul(clippedLog(label).Sub(clippedLog(score)))
+	})
+})
+
+// Poisson negative log-likelihood: mean(p_i - y_i*log(p_i)) where scores are
+// predicted rates, e.g. outputs of an Exp activation, and labels are counts.
+// The constant log(y_i!) is omitted.
+var PoissonNLL LossFunc = lossFunc(func(labels, scores []*Value) *Value {
+	return meanLoss(labels, scores, func(label, score *Value) *Value {
+		return score.Sub(label.Mul(clippedLog(score)))
+	})
+})
+
+// Focal loss down-weighting well-classified records by (1-p_t)^gamma so
+// training focuses on hard records, where p_t is the probability of the
+// label. For a single output it is
+//
+//	-(alpha*y*(1-p)^gamma*log(p) + (1-alpha)*(1-y)*p^gamma*log(1-p))
+//
+// and -alpha*sum(y_i*(1-p_i)^gamma*log(p_i)) otherwise. alpha weights the
+// positive class of binary labels, and gamma = 0, alpha = 0.5 is half of the
+// cross-entropy.
+func FocalLoss(gamma, alpha float64) LossFunc {
+	// Returns x^gamma with x clipped to [lossEpsilon, 1], so the gradient is
+	// finite for saturated probabilities.
+	modulate := func(x *Value) *Value {
+		if gamma == 0.0 {
+			return MakeValue(1.0)
+		}
+		return clippedProbability(x).Pow(gamma)
+	}
+	return classificationLoss(func(labels, scores []*Value) *Value {
+		if len(scores) == 1 {
+			label, p := labels[0], scores[0]
+			q := MakeValue(1.0).Sub(p)
+			pos := label.Mul(modulate(q)).Mul(clippedLog(p)).Mul(MakeValue(alpha))
+			neg := MakeValue(1.0).Sub(label).Mul(modulate(p)).Mul(clippedLog(q)).Mul(MakeValue(1.0 - alpha))
+			return pos.Add(neg).Mul(MakeValue(-1.0))
+		}
+		return sumLoss(labels, scores, func(label, p *Value) *Value {
+			q := MakeValue(1.0).Sub(p)
+			return label.Mul(modulate(q)).Mul(clippedLog(p)).Mul(MakeValue(-alpha))
+		})
+	})
+}
+
+// Returns labels smoothed towards the uniform distribution:
+// y*(1-epsilon) + epsilon/k where k is the number of classes, which is 2 for
+// a single output.
+func smoothLabels(labels []*Value, epsilon float64) []*Value {
+	k := float64(len(labels))
+	if k == 1 {
+		k = 2
+	}
+	ans := make([]*Value, len(labels))
+	for i, label := range labels {
+		ans[i] = MakeValue(label.data*(1-epsilon) + epsilon/k)
+	}
+	return ans
+}
+
+// Binary cross-entropy for a single output, categorical cross-entropy for
+// probabilities summing to 1, e.g. of Softmax, and the mean binary
+// cross-entropy of the outputs for other outputs in [0, 1], e.g. of Sigmoid
+// for multi-label classification. Panics on other outputs, e.g. of an
+// element-wise activation like Exp instead of Softmax.
+var defaultLoss LossFunc = classificationLoss(func(labels, scores []*Value) *Value {
+	if len(scores) == 1 {
+		return BinaryCrossEntropy.Loss(labels, scores)
+	}
+	sum, probabilities := 0.0, true
+	for _, score := range scores {
+		if !(score.data >= 0.0 && score.data <= 1.0) {
+			probabilities = false
+		}
+		sum += score.data
+	}
+	if !probabilities {
+		panic(fmt.Sprintf("the default loss expects outputs in [0, 1], got a sum of %f; use MakeVectorLayerParam(size, Softmax) for multi-class or Sigmoid for multi-label outputs, or set LossFunc", sum))
+	}
+	if math.Abs(sum-1.0) >= 1e-6 {
+		return BinaryCrossEntropy.Loss(labels, scores)
+	}
+	return CategoricalCrossEntropy.Loss(labels, scores)
+})
@@ -0,0 +1,203 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLossFuncs(t *testing.T) {
+	tests := map[string]struct {
+		lossFunc       LossFunc
+		labels, scores []float64
+		expected       float64
+	}{
+		"MeanSquaredError":        {MeanSquaredError, []float64{1.0, -2.0}, []float64{0.5, 1.0}, (0.25 + 9.0) / 2},
+		"MeanAbsoluteError":       {MeanAbsoluteError, []float64{1.0, -2.0}, []float64{0.5, 1.0}, (0.5 + 3.0) / 2},
+		"Huber":                   {Huber(1.0), []float64{1.0, -2.0}, []float64{0.5, 1.0}, (0.125 + 2.5) / 2},
+		"Hinge":                   {Hinge, []float64{1.0, 0.0}, []float64{0.3, -2.0}, (0.7 + 0.0) / 2},
+		"SquaredHinge":            {SquaredHinge, []float64{-1.0, 1.0}, []float64{0.5, 1.5}, (2.25 + 0.0) / 2},
+		"BinaryCrossEntropy":      {BinaryCrossEntropy, []float64{1.0, 0.0}, []float64{0.8, 0.4}, -(math.Log(0.8) + math.Log(0.6)) / 2},
+		"CategoricalCrossEntropy": {CategoricalCrossEntropy, []float64{0.0, 1.0, 0.0}, []float64{0.2, 0.7, 0.1}, -math.Log(0.7)},
+		"KLDivergence":            {KLDivergence, []float64{0.5, 0.5, 0.0}, []float64{0.25, 0.5, 0.25}, 0.5 * math.Log(2.0)},
+		"PoissonNLL":              {PoissonNLL, []float64{2.0, 0.0}, []float64{1.5, 0.5}, (1.5 - 2.0*math.Log(1.5) + 0.5) / 2},
+	}
+	for name, test := range tests {
+		labels := makeRecords([][]float64{test.labels})[0]
+		scores := makeRecords([][]float64{test.scores})[0]
+		loss := test.lossFunc.Loss(labels, scores).GetData()
+		assert.InDelta(t, test.expected, loss, 1e-12, "%s: expected %f, got %f", name, test.expected, loss)
+
+		checkGradient(t, scores, func() *Value {
+			return test.lossFunc.Loss(labels, scores)
+		})
+	}
+}
+
+func TestFocalLoss(t *testing.T) {
+	labels := makeRecords([][]float64{{1.0}, {0.0}})
+	scores := makeRecords([][]float64{{0.8}, {0.4}})
+	for i := range labels {
+		// Without focusing and with alpha = 0.5 it is half of the cross-entropy.
+		expected := 0.5 * BinaryCrossEntropy.Loss(labels[i], scores[i]).GetData()
+		actual := FocalLoss(0.0, 0.5).Loss(labels[i], scores[i]).GetData()
+		assert.InDelta(t, expected, actual, 1e-12, "expected %f, got %f", expected, actual)
+		checkGradient(t, scores[i], func() *Value { return FocalLoss(2.0, 0.25).Loss(labels[i], scores[i]) })
+	}
+	expected := -0.25 * 0.04 * math.Log(0.8)
+	actual := FocalLoss(2.0, 0.25).Loss(labels[0], scores[0]).GetData()
+	assert.InDelta(t, expected, actual, 1e-12, "expected %f, got %f", expected, actual)
+	expected = -0.75 * 0.16 * math.Log(0.6)
+	actual = FocalLoss(2.0, 0.25).Loss(labels[1], scores[1]).GetData()
+	assert.InDelta(t, expected, actual, 1e-12, "expected %f, got %f", expected, actual)
+
+	// Well-classified records are down-weighted more than hard ones.
+	multi := makeRecords([][]float64{{0.0, 1.0, 0.0}})[0]
+	easy := makeRecords([][]float64{{0.05, 0.9, 0.05}})[0]
+	hard := makeRecords([][]float64{{0.6, 0.3, 0.1}})[0]
+	ratio := func(scores []*Value) float64 {
+		return FocalLoss(2.0, 1.0).Loss(multi, scores).GetData() / CategoricalCrossEntropy.Loss(multi, scores).GetData()
+	}
+	assert.InDelta(t, 0.01, ratio(easy), 1e-12, "expected %f, got %f", 0.01, ratio(easy))
+	assert.InDelta(t, 0.49, ratio(hard), 1e-12, "expected %f, got %f", 0.49, ratio(hard))
+	checkGradient(t, hard, func() *Value { return FocalLoss(2.0, 1.0).Loss(multi, hard) })
+
+	// Gradients of saturated sigmoids are finite.
+	for _, gamma := range []float64{0.0, 0.5, 2.0} {
+		for _, x := range []float64{40.0, -40.0} {
+			for _, label := range []float64{0.0, 1.0} {
+				input := MakeValue(x)
+				loss := FocalLoss(gamma, 0.25).Loss([]*Value{MakeValue(label)}, []*Value{Sigmoid(input)})
+				loss.BackPropagate()
+				assert.False(t, math.IsNaN(loss.GetData()) || math.IsInf(loss.GetData(), 0), "gamma %f, x %f, label %f: got loss %f", gamma, x, label, loss.GetData())
+				assert.False(t, math.IsNaN(input.GetGrad()) || math.IsInf(input.GetGrad(), 0), "gamma %f, x %f, label %f: got gradient %f", gamma, x, label, input.GetGrad())
+			}
+		}
+	}
+}
+
+func TestLabelSmoothing(t *testing.T) {
+	model := MakeModel()
+	labels := makeRecords([][]float64{{1.0}, {0.0}})
+	scores := makeRecords([][]float64{{0.8}, {0.4}})
+	trainingParam := TrainingParam{LabelSmoothing: 0.2}
+	// Labels become 0.9 and 0.1.
+	expected := -(0.9*math.Log(0.8) + 0.1*math.Log(0.2) + 0.1*math.Log(0.4) + 0.9*math.Log(0.6)) / 2
+	actual := model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, expected, actual, 1e-12, "expected %f, got %f", expected, actual)
+
+	// Labels of k classes become 1-epsilon+epsilon/k and epsilon/k.
+	multi := makeRecords([][]float64{{0.0, 1.0, 0.0, 0.0}})
+	probs := makeRecords([][]float64{{0.1, 0.7, 0.1, 0.1}})
+	expected = -(0.85*math.Log(0.7) + 3*0.05*math.Log(0.1))
+	actual = model.Loss(multi, probs, trainingParam).GetData()
+	assert.InDelta(t, expected, actual, 1e-12, "expected %f, got %f", expected, actual)
+	// The labels themselves are unchanged.
+	assert.Equal(t, 1.0, multi[0][1].GetData())
+
+	// Regression targets are not smoothed.
+	assert.Panics(t, func() {
+		model.Loss(labels, scores, TrainingParam{LabelSmoothing: 0.2, LossFunc: MeanSquaredError})
+	})
+	assert.NotPanics(t, func() {
+		model.Loss(labels, scores, TrainingParam{LabelSmoothing: 0.2, LossFunc: FocalLoss(2.0, 0.25)})
+	})
+}
+
+func TestLossStability(t *testing.T) {
+	labels := makeRecords([][]float64{{1.0, 0.0}})[0]
+	scores := makeRecords([][]float64{{0.0, 1.0}})[0]
+	for name, lossFunc := range map[string]LossFunc{
+		"BinaryCrossEntropy":      BinaryCrossEntropy,
+		"CategoricalCrossEntropy": CategoricalCrossEntropy,
+		"KLDivergence":            KLDivergence,
+		"PoissonNLL":              PoissonNLL,
+	} {
+		loss := lossFunc.Loss(labels, scores)
+		assert.False(t, math.IsInf(loss.GetData(), 0) || math.IsNaN(loss.GetData()), "%s: expected a finite loss, got %f", name, loss.GetData())
+		for _, score := range scores {
+			grad := Gradient([]*Value{score}, func() *Value { return lossFunc.Loss(labels, scores) })[0]
+			assert.False(t, math.IsInf(grad, 0) || math.IsNaN(grad), "%s: expected a finite gradient, got %f", name, grad)
+		}
+	}
+}
+
+func TestRegression(t *testing.T) {
+	// y = 2*x_1 - x_2 + 0.5
+	inputs := makeRecords([][]float64{{0.0, 0.0}, {1.0, 0.0}, {0.0, 1.0}, {1.0, 1.0}, {0.5, -1.0}})
+	labels := makeRecords([][]float64{{0.5}, {2.5}, {-0.5}, {1.5}, {2.5}})
+	for name, lossFunc := range map[string]LossFunc{
+		"MeanSquaredError":  MeanSquaredError,
+		"MeanAbsoluteError": MeanAbsoluteError,
+		"Huber":             Huber(1.0),
+	} {
+		model := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(1, nil)}, rand.New(rand.NewSource(seed)))
+		trainingParam := TrainingParam{Epochs: 500, LearningRate: 0.1, LossFunc: lossFunc}
+		losses, _, _ := model.Train(inputs, labels, trainingParam)
+		assert.Less(t, losses[len(losses)-1], 0.05, "%s: expected loss < %f, got %f", name, 0.05, losses[len(losses)-1])
+		assert.InDelta(t, 3.5, model.Predict([][]*Value{{MakeValue(2.0), MakeValue(1.0)}})[0][0].GetData(), 0.2, name)
+	}
+}
+
+func TestWeightedLoss(t *testing.T) {
+	model := MakeModel()
+	labels := makeRecords([][]float64{{1.0}, {0.0}, {0.0}})
+	scores := makeRecords([][]float64{{0.8}, {0.6}, {0.1}})
+	losses := []float64{-math.Log(0.8), -math.Log(0.4), -math.Log(0.9)}
+
+	trainingParam := TrainingParam{ClassificationThreshold: 0.5, SampleWeights: []float64{2.0, 1.0, 0.0}}
+	expected := (2*losses[0] + losses[1]) / 3
+	loss := model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, expected, loss, 1e-12, "expected %f, got %f", expected, loss)
+	// The second record is misclassified.
+	accuracy := Accuracy(scores, labels, trainingParam)
+	assert.InDelta(t, 2.0/3, accuracy, 1e-12, "expected %f, got %f", 2.0/3, accuracy)
+
+	// Class weights are multiplied by sample weights.
+	trainingParam.ClassWeights = []float64{1.0, 3.0}
+	expected = (6*losses[0] + losses[1]) / 7
+	loss = model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, expected, loss, 1e-12, "expected %f, got %f", expected, loss)
+	accuracy = Accuracy(scores, labels, trainingParam)
+	assert.InDelta(t, 6.0/7, accuracy, 1e-12, "expected %f, got %f", 6.0/7, accuracy)
+
+	// Balanced weights are 3/(2*1) for class 1 and 3/(2*2) for class 0.
+	trainingParam = TrainingParam{ClassificationThreshold: 0.5, BalanceClasses: true}
+	assert.Equal(t, []float64{0.75, 1.5}, BalancedClassWeights(labels, trainingParam))
+	expected = (1.5*losses[0] + 0.75*losses[1] + 0.75*losses[2]) / 3
+	loss = model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, expected, loss, 1e-12, "expected %f, got %f", expected, loss)
+	accuracy = Accuracy(scores, labels, trainingParam)
+	assert.InDelta(t, 0.75, accuracy, 1e-12, "expected %f, got %f", 0.75, accuracy)
+
+	// Classes of multi-class labels are the indices of their largest values.
+	multi := makeRecords([][]float64{{0.0, 1.0, 0.0}, {0.0, 1.0, 0.0}, {1.0, 0.0, 0.0}})
+	assert.Equal(t, []float64{1.0, 0.5, 0.0}, BalancedClassWeights(multi, TrainingParam{}))
+
+	// Weights adding up to zero have no mean.
+	zero := TrainingParam{ClassificationThreshold: 0.5, SampleWeights: []float64{0.0, 0.0, 0.0}}
+	assert.Panics(t, func() { model.Loss(labels, scores, zero) })
+	assert.Panics(t, func() { Accuracy(scores, labels, zero) })
+
+	assert.Panics(t, func() { model.Loss(labels, scores, TrainingParam{SampleWeights: []float64{1.0}}) })
+	assert.Panics(t, func() {
+		model.Loss(labels, scores, TrainingParam{ClassWeights: []float64{1.0}, ClassificationThreshold: 0.5})
+	})
+}
+
+func TestImbalancedTraining(t *testing.T) {
+	// A single positive record among negatives which is only classified
+	// correctly with balanced class weights.
+	inputs := makeRecords([][]float64{{0.0}, {0.1}, {0.2}, {0.3}, {0.4}, {0.5}, {0.6}, {0.7}, {0.8}, {1.0}})
+	labels := makeRecords([][]float64{{0.0}, {0.0}, {0.0}, {0.0}, {0.0}, {0.0}, {0.0}, {0.0}, {0.0}, {1.0}})
+	positive := [][]*Value{inputs[9]}
+	for _, balance := range []bool{false, true} {
+		model := MakeNeuralNetwork(1, []LayerParam{MakeLayerParam(1, Sigmoid)}, rand.New(rand.NewSource(seed)))
+		trainingParam := TrainingParam{Epochs: 200, LearningRate: 0.5, ClassificationThreshold: 0.5, BalanceClasses: balance}
+		model.Train(inputs, labels, trainingParam)
+		score := model.Predict(positive)[0][0].GetData()
+		assert.Equal(t, balance, score > 0.5, "balanced %t: got score %f", balance, score)
+	}
+}
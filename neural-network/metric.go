@@ -0,0 +1,271 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// A pair loss computes the loss of a pair of records given the outputs of
+// the network for both records and the label of the pair.
+type PairLoss interface {
+	Loss(label *Value, first, second []*Value) *Value
+}
+
+// Adapts a function to the PairLoss interface.
+type pairLoss func(label *Value, first, second []*Value) *Value
+
+func (f pairLoss) Loss(label *Value, first, second []*Value) *Value {
+	return f(label, first, second)
+}
+
+// A triplet loss computes the loss of an anchor record, a positive record
+// similar to it and a negative record dissimilar to it given the outputs of
+// the network for the three records.
+type TripletLoss interface {
+	Loss(anchor, positive, negative []*Value) *Value
+}
+
+// Adapts a function to the TripletLoss interface.
+type tripletLoss func(anchor, positive, negative []*Value) *Value
+
+func (f tripletLoss) Loss(anchor, positive, negative []*Value) *Value {
+	return f(anchor, positive, negative)
+}
+
+// Squared Euclidean distance of two embeddings: sum((a_i - b_i)^2)
+func squaredDistance(a, b []*Value) *Value {
+	return sumLoss(a, b, func(x, y *Value) *Value {
+		return y.Sub(x).Pow(2)
+	})
+}
+
+// Contrastive loss of a pair of embeddings with distance d:
+// y*d^2 + (1-y)*max(0, margin - d)^2 where the label y is 1 for similar
+// records and 0 for dissimilar records. Similar records are pulled together
+// and dissimilar records are pushed at least margin apart.
+func Contrastive(margin float64) PairLoss {
+	return pairLoss(func(label *Value, first, second []*Value) *Value {
+		squared := squaredDistance(first, second)
+		// lossEpsilon keeps the gradient finite at d = 0.
+		distance := squared.Add(MakeValue(lossEpsilon)).Pow(0.5)
+		pos := label.Mul(squared)
+		neg := MakeValue(1.0).Sub(label).Mul(Relu(MakeValue(margin).Sub(distance)).Pow(2))
+		return pos.Add(neg)
+	})
+}
+
+// Triplet loss of embeddings: max(0, d(a, p)^2 - d(a, n)^2 + margin) where d
+// is the Euclidean distance. The negative is pushed at least margin further
+// from the anchor than the positive.
+func Triplet(margin float64) TripletLoss {
+	return tripletLoss(func(anchor, positive, negative []*Value) *Value {
+		pos, neg := squaredDistance(anchor, positive), squaredDistance(anchor, negative)
+		return Relu(pos.Sub(neg).Add(MakeValue(margin)))
+	})
+}
+
+// RankNet pairwise ranking loss: the binary cross-entropy of the label y,
+// the probability that the first record ranks above the second, and
+// sigmoid(s_1 - s_2) where s_1 and s_2 are the single outputs of the network
+// for the records. It is computed as softplus(s_1 - s_2) - y*(s_1 - s_2),
+// which is stable for large score differences.
+var RankNet PairLoss = pairLoss(func(label *Value, first, second []*Value) *Value {
+	diff := first[0].Sub(second[0])
+	return Softplus(diff).Sub(label.Mul(diff))
+})
+
+// Trains the network on pairs of records by minimizing the mean of the
+// losses of the pairs. Both records of a pair are mapped by the same
+// network. Sample weights in trainingParam weight the pairs, and class
+// weights are ignored. Validation records, accumulation steps, mixup and
+// LossFunc aren't supported and panic. Returns the loss and the learning
+// rate of each epoch.
+func (n *NeuralNetwork) TrainPairs(firsts, seconds [][]*Value, labels []*Value, lossFunc PairLoss, trainingParam TrainingParam) ([]float64, []float64) {
+	return n.trainTuples(len(labels), trainingParam, func() []*Value {
+		firstOutputs, secondOutputs := n.Forward(firsts), n.Forward(seconds)
+		losses := make([]*Value, len(labels))
+		for i, label := range labels {
+			losses[i] = lossFunc.Loss(label, firstOutputs[i], secondOutputs[i])
+		}
+		return losses
+	})
+}
+
+// Trains the network on triplets of records by minimizing the mean of the
+// losses of the triplets like TrainPairs. Returns the loss and the learning
+// rate of each epoch.
+func (n *NeuralNetwork) TrainTriplets(anchors, positives, negatives [][]*Value, lossFunc TripletLoss, trainingParam TrainingParam) ([]float64, []float64) {
+	return n.trainTuples(len(anchors), trainingParam, func() []*Value {
+		anchorOutputs, positiveOutputs, negativeOutputs := n.Forward(anchors), n.Forward(positives), n.Forward(negatives)
+		losses := make([]*Value, len(anchors))
+		for i := range losses {
+			losses[i] = lossFunc.Loss(anchorOutputs[i], positiveOutputs[i], negativeOutputs[i])
+		}
+		return losses
+	})
+}
+
+// Trains the network by minimizing the weighted mean of the losses of
+// numTuples tuples computed by tupleLosses, plus the regularization penalty.
+func (n *NeuralNetwork) trainTuples(numTuples int, trainingParam TrainingParam, tupleLosses func() []*Value) ([]float64, []float64) {
+	weights := trainingParam.SampleWeights
+	if weights != nil && len(weights) != numTuples {
+		panic(fmt.Sprintf("expected %d sample weights, got %d", numTuples, len(weights)))
+	}
+	if trainingParam.ValidationInputs != nil || trainingParam.AccumulationSteps > 1 || trainingParam.MixupAlpha > 0.0 || trainingParam.LossFunc != nil {
+		panic("training on tuples does not support ValidationInputs, AccumulationSteps, MixupAlpha and LossFunc")
+	}
+	trainingParam.validateHessianFree()
+	n.SetTraining(true)
+	defer n.SetTraining(false)
+
+	lossOf := func() *Value {
+		loss := weightedMean(tupleLosses(), weights)
+		if penalty := n.regularization(trainingParam); penalty != nil {
+			loss = loss.Add(penalty)
+		}
+		return loss
+	}
+	losses := make([]float64, trainingParam.Epochs)
+	learningRates := make([]float64, trainingParam.Epochs)
+	for i := range losses {
+		loss := lossOf()
+		losses[i] = loss.GetData()
+
+		if trainingParam.HessianFree != nil {
+			// HessianFree doesn't use a learning rate.
+			learningRates[i] = math.NaN()
+			// Re-evaluations of the loss don't update the running statistics.
+			updateStatistics(n.modules)
+			trainingParam.HessianFree.Step(trainable(n.Parameters()), loss, lossOf)
+			discardStatistics(n.modules)
+			n.constrain(trainingParam)
+		} else {
+			learningRates[i] = trainingParam.learningRateAt(i)
+			n.ResetGrad()
+			loss.BackPropagate()
+			n.step(trainingParam, learningRates[i])
+		}
+		trainingParam.observe(losses[i])
+	}
+	return losses, learningRates
+}
+
+// Records of labeled lines grouped by their labels.
+type labeledRecords struct {
+	inputs [][]*Value
+	labels []float64
+	// Distinct labels in increasing order.
+	classes []float64
+	// Indices of the records of each label.
+	groups map[float64][]int
+}
+
+// Reads the records of the lines, where the last column is the label, and
+// groups them by their labels. Panics if there are less than two labels.
+func makeLabeledRecords(lines [][]string) labeledRecords {
+	records := labeledRecords{
+		inputs: make([][]*Value, len(lines)),
+		labels: make([]float64, len(lines)),
+		groups: map[float64][]int{},
+	}
+	for i, line := range lines {
+		input, label := getRecord(line)
+		records.inputs[i], records.labels[i] = input, label.data
+		if _, ok := records.groups[label.data]; !ok {
+			records.classes = append(records.classes, label.data)
+		}
+		records.groups[label.data] = append(records.groups[label.data], i)
+	}
+	if len(records.classes) < 2 {
+		panic(fmt.Sprintf("expected at least 2 labels, got %d", len(records.classes)))
+	}
+	sort.Float64s(records.classes)
+	return records
+}
+
+// Returns a random record with the same label as record i, other than i if
+// possible.
+func (r labeledRecords) similar(i int, rng *rand.Rand) int {
+	group := r.groups[r.labels[i]]
+	if len(group) == 1 {
+		return i
+	}
+	for {
+		if j := group[rng.Intn(len(group))]; j != i {
+			return j
+		}
+	}
+}
+
+// Returns a random record with a different label from record i, where the
+// label is first drawn uniformly from the other labels.
+func (r labeledRecords) dissimilar(i int, rng *rand.Rand) int {
+	c := rng.Intn(len(r.classes) - 1)
+	if r.classes[c] >= r.labels[i] {
+		// Skips the label of record i.
+		c++
+	}
+	group := r.groups[r.classes[c]]
+	return group[rng.Intn(len(group))]
+}
+
+// Returns numPairs random pairs of records of the lines, where the last
+// column is the label, and the label of each pair which is 1 if both records
+// have the same label and 0 otherwise. Half of the pairs are similar. The
+// pairs can be used with Contrastive.
+func GetPairs(lines [][]string, numPairs int, rng *rand.Rand) ([][]*Value, [][]*Value, []*Value) {
+	records := makeLabeledRecords(lines)
+	firsts := make([][]*Value, numPairs)
+	seconds := make([][]*Value, numPairs)
+	labels := make([]*Value, numPairs)
+	for k := range labels {
+		i := rng.Intn(len(lines))
+		j, label := records.dissimilar(i, rng), 0.0
+		if k%2 == 0 {
+			j, label = records.similar(i, rng), 1.0
+		}
+		firsts[k], seconds[k], labels[k] = records.inputs[i], records.inputs[j], MakeValue(label)
+	}
+	return firsts, seconds, labels
+}
+
+// Returns numTriplets random triplets of records of the lines, where the
+// last column is the label. Each triplet has an anchor, a positive record
+// with the same label and a negative record with a different label. The
+// triplets can be used with Triplet.
+func GetTriplets(lines [][]string, numTriplets int, rng *rand.Rand) ([][]*Value, [][]*Value, [][]*Value) {
+	records := makeLabeledRecords(lines)
+	anchors := make([][]*Value, numTriplets)
+	positives := make([][]*Value, numTriplets)
+	negatives := make([][]*Value, numTriplets)
+	for k := range anchors {
+		i := rng.Intn(len(lines))
+		anchors[k] = records.inputs[i]
+		positives[k] = records.inputs[records.similar(i, rng)]
+		negatives[k] = records.inputs[records.dissimilar(i, rng)]
+	}
+	return anchors, positives, negatives
+}
+
+// Returns numPairs random pairs of records of the lines with different
+// labels, where the last column is the relevance of a record, and the label
+// of each pair which is 1 if the first record is more relevant and 0
+// otherwise. The pairs can be used with RankNet.
+func GetRankingPairs(lines [][]string, numPairs int, rng *rand.Rand) ([][]*Value, [][]*Value, []*Value) {
+	records := makeLabeledRecords(lines)
+	firsts := make([][]*Value, numPairs)
+	seconds := make([][]*Value, numPairs)
+	labels := make([]*Value, numPairs)
+	for k := range labels {
+		i := rng.Intn(len(lines))
+		j, label := records.dissimilar(i, rng), 0.0
+		if records.labels[i] > records.labels[j] {
+			label = 1.0
+		}
+		firsts[k], seconds[k], labels[k] = records.inputs[i], records.inputs[j], MakeValue(label)
+	}
+	return firsts, seconds, labels
+}
@@ -0,0 +1,200 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricLosses(t *testing.T) {
+	origin := makeRecords([][]float64{{0.0, 0.0}})[0]
+	near := makeRecords([][]float64{{0.3, 0.4}})[0]
+	far := makeRecords([][]float64{{1.2, 1.6}})[0]
+	contrastive := Contrastive(1.0)
+	pairs := []struct {
+		label         float64
+		first, second []*Value
+		expected      float64
+	}{
+		{1.0, origin, near, 0.25},
+		{0.0, origin, near, 0.25},
+		{1.0, origin, far, 4.0},
+		{0.0, origin, far, 0.0},
+	}
+	for _, pair := range pairs {
+		label := MakeValue(pair.label)
+		loss := contrastive.Loss(label, pair.first, pair.second).GetData()
+		assert.InDelta(t, pair.expected, loss, 1e-9, "expected %f, got %f", pair.expected, loss)
+		checkGradient(t, pair.second, func() *Value { return contrastive.Loss(label, pair.first, pair.second) })
+	}
+	// The gradient is finite for identical embeddings.
+	grad := Gradient(near, func() *Value { return contrastive.Loss(MakeValue(0.0), near, near) })
+	assert.False(t, math.IsNaN(grad[0]) || math.IsInf(grad[0], 0), "expected a finite gradient, got %f", grad[0])
+
+	anchor := makeRecords([][]float64{{0.0, 0.0}})[0]
+	positive := makeRecords([][]float64{{1.0, 0.0}})[0]
+	triplet := Triplet(0.5)
+	for _, test := range []struct {
+		negative []float64
+		expected float64
+	}{
+		{[]float64{0.0, 2.0}, 0.0},
+		{[]float64{1.0, 1.0}, 0.0},
+		{[]float64{0.5, 0.5}, 1.0},
+	} {
+		negative := makeRecords([][]float64{test.negative})[0]
+		loss := triplet.Loss(anchor, positive, negative).GetData()
+		assert.InDelta(t, test.expected, loss, 1e-12, "expected %f, got %f", test.expected, loss)
+	}
+	negative := makeRecords([][]float64{{0.5, 0.5}})[0]
+	values := append(append(append([]*Value{}, anchor...), positive...), negative...)
+	checkGradient(t, values, func() *Value { return triplet.Loss(anchor, positive, negative) })
+
+	first, second := []*Value{MakeValue(2.0)}, []*Value{MakeValue(0.5)}
+	for _, y := range []float64{0.0, 0.5, 1.0} {
+		label := MakeValue(y)
+		p := 1.0 / (1.0 + math.Exp(-1.5))
+		expected := -(y*math.Log(p) + (1-y)*math.Log(1-p))
+		loss := RankNet.Loss(label, first, second).GetData()
+		assert.InDelta(t, expected, loss, 1e-12, "expected %f, got %f", expected, loss)
+		checkGradient(t, []*Value{first[0], second[0]}, func() *Value { return RankNet.Loss(label, first, second) })
+	}
+	// Large score differences do not overflow.
+	loss := RankNet.Loss(MakeValue(0.0), []*Value{MakeValue(1000.0)}, []*Value{MakeValue(0.0)}).GetData()
+	assert.InDelta(t, 1000.0, loss, 1e-9, "expected %f, got %f", 1000.0, loss)
+}
+
+func TestGetPairs(t *testing.T) {
+	// The input of each line is its index and the label is the index mod 3.
+	lines := make([][]string, 30)
+	for i := range lines {
+		lines[i] = []string{strconv.Itoa(i), strconv.Itoa(i % 3)}
+	}
+	class := func(input []*Value) int { return int(input[0].GetData()) % 3 }
+
+	rng := rand.New(rand.NewSource(seed))
+	firsts, seconds, labels := GetPairs(lines, 20, rng)
+	similar := 0
+	for i, label := range labels {
+		expected := 0.0
+		if class(firsts[i]) == class(seconds[i]) {
+			expected = 1.0
+			similar++
+			assert.NotEqual(t, firsts[i][0].GetData(), seconds[i][0].GetData(), "pair %d is a record with itself", i)
+		}
+		assert.Equal(t, expected, label.GetData(), "pair %d: expected label %f, got %f", i, expected, label.GetData())
+	}
+	assert.Equal(t, 10, similar)
+	// The same seed generates the same pairs.
+	againFirsts, againSeconds, _ := GetPairs(lines, 20, rand.New(rand.NewSource(seed)))
+	for i := range firsts {
+		assert.Equal(t, firsts[i][0].GetData(), againFirsts[i][0].GetData())
+		assert.Equal(t, seconds[i][0].GetData(), againSeconds[i][0].GetData())
+	}
+
+	anchors, positives, negatives := GetTriplets(lines, 20, rng)
+	for i := range anchors {
+		assert.Equal(t, class(anchors[i]), class(positives[i]), "triplet %d: expected a positive record", i)
+		assert.NotEqual(t, class(anchors[i]), class(negatives[i]), "triplet %d: expected a negative record", i)
+	}
+
+	firsts, seconds, labels = GetRankingPairs(lines, 20, rng)
+	for i, label := range labels {
+		assert.NotEqual(t, class(firsts[i]), class(seconds[i]), "pair %d: expected different relevances", i)
+		expected := 0.0
+		if class(firsts[i]) > class(seconds[i]) {
+			expected = 1.0
+		}
+		assert.Equal(t, expected, label.GetData(), "pair %d: expected label %f, got %f", i, expected, label.GetData())
+	}
+
+	assert.Panics(t, func() { GetPairs([][]string{{"0", "1"}, {"1", "1"}}, 2, rng) })
+}
+
+// Returns the fraction of triplets whose anchor is closer to the positive
+// than to the negative in the embedding of the model.
+func tripletAccuracy(model *NeuralNetwork, anchors, positives, negatives [][]*Value) float64 {
+	a, p, n := model.Predict(anchors), model.Predict(positives), model.Predict(negatives)
+	correct := 0.0
+	for i := range a {
+		if squaredDistance(a[i], p[i]).GetData() < squaredDistance(a[i], n[i]).GetData() {
+			correct++
+		}
+	}
+	return correct / float64(len(a))
+}
+
+func TestTrainTriplets(t *testing.T) {
+	lines := ReadCSV("../data/make_moon.csv")[1:]
+	rng := rand.New(rand.NewSource(seed))
+	anchors, positives, negatives := GetTriplets(lines[:200], 100, rng)
+	testAnchors, testPositives, testNegatives := GetTriplets(lines[200:400], 200, rng)
+	layerParams := []LayerParam{MakeLayerParam(8, Tanh), MakeLayerParam(2, nil)}
+
+	for name, train := range map[string]func(*NeuralNetwork) []float64{
+		"Triplet": func(model *NeuralNetwork) []float64 {
+			trainingParam := TrainingParam{Epochs: 60, LearningRate: 0.1}
+			losses, _ := model.TrainTriplets(anchors, positives, negatives, Triplet(1.0), trainingParam)
+			return losses
+		},
+		"Contrastive": func(model *NeuralNetwork) []float64 {
+			firsts, seconds, labels := GetPairs(lines[:200], 100, rand.New(rand.NewSource(seed)))
+			trainingParam := TrainingParam{Epochs: 60, LearningRate: 0.1}
+			losses, _ := model.TrainPairs(firsts, seconds, labels, Contrastive(1.0), trainingParam)
+			return losses
+		},
+	} {
+		model := MakeNeuralNetwork(2, layerParams, rand.New(rand.NewSource(seed)))
+		before := tripletAccuracy(model, testAnchors, testPositives, testNegatives)
+		losses := train(model)
+		assert.Less(t, losses[len(losses)-1], losses[0], "%s: expected loss to decrease from %f, got %f", name, losses[0], losses[len(losses)-1])
+		after := tripletAccuracy(model, testAnchors, testPositives, testNegatives)
+		assert.Greater(t, after, before, "%s: expected accuracy to increase from %f, got %f", name, before, after)
+		assert.Greater(t, after, 0.8, "%s: expected accuracy > %f, got %f", name, 0.8, after)
+		assert.False(t, model.Training(), name)
+	}
+
+	model := MakeNeuralNetwork(2, layerParams, rng)
+	for name, trainingParam := range map[string]TrainingParam{
+		"HessianFree":       {Epochs: 1, HessianFree: MakeHessianFree(), ClipNorm: 1.0},
+		"ValidationInputs":  {Epochs: 1, ValidationInputs: anchors},
+		"AccumulationSteps": {Epochs: 1, AccumulationSteps: 2},
+		"MixupAlpha":        {Epochs: 1, MixupAlpha: 0.2},
+		"LossFunc":          {Epochs: 1, LossFunc: MeanSquaredError},
+	} {
+		assert.Panics(t, func() { model.TrainTriplets(anchors, positives, negatives, Triplet(1.0), trainingParam) }, name)
+	}
+}
+
+func TestTrainRanking(t *testing.T) {
+	// The relevance of x is the bucket of 2*x_1 - x_2.
+	rng := rand.New(rand.NewSource(seed))
+	lines := make([][]string, 100)
+	for i := range lines {
+		x1, x2 := rng.Float64(), rng.Float64()
+		relevance := math.Floor(2*x1 - x2 + 1.0)
+		lines[i] = []string{fmt.Sprint(x1), fmt.Sprint(x2), fmt.Sprint(relevance)}
+	}
+	firsts, seconds, labels := GetRankingPairs(lines, 200, rng)
+	model := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(1, nil)}, rng)
+	losses, _ := model.TrainPairs(firsts, seconds, labels, RankNet, TrainingParam{Epochs: 100, LearningRate: 0.5})
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+
+	// Pairs are ordered by the scores of the model.
+	firstScores, secondScores := model.Predict(firsts), model.Predict(seconds)
+	correct := 0.0
+	for i, label := range labels {
+		if (firstScores[i][0].GetData() > secondScores[i][0].GetData()) == (label.GetData() == 1.0) {
+			correct++
+		}
+	}
+	accuracy := correct / float64(len(labels))
+	assert.Greater(t, accuracy, 0.9, "expected accuracy > %f, got %f", 0.9, accuracy)
+
+	weights := make([]float64, len(labels))
+	assert.Panics(t, func() { model.TrainPairs(firsts, seconds, labels, RankNet, TrainingParam{SampleWeights: weights[1:]}) })
+}
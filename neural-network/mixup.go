@@ -0,0 +1,70 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Blends each record with a record of a random permutation of the records:
+// x = lambda*x_i + (1-lambda)*x_j and y = lambda*y_i + (1-lambda)*y_j where
+// lambda is drawn from Beta(MixupAlpha, MixupAlpha) once per call. Sample
+// weights are blended the same way.
+func mixup(inputs, labels [][]*Value, trainingParam TrainingParam) ([][]*Value, [][]*Value, TrainingParam) {
+	rng := trainingParam.Rand
+	if rng == nil {
+		panic("mixup requires TrainingParam.Rand")
+	}
+	lambda := sampleBeta(trainingParam.MixupAlpha, trainingParam.MixupAlpha, rng)
+	blend := func(a, b []*Value) []*Value {
+		ans := make([]*Value, len(a))
+		for k := range a {
+			ans[k] = MakeValue(lambda*a[k].data + (1-lambda)*b[k].data)
+		}
+		return ans
+	}
+
+	perm := rng.Perm(len(inputs))
+	mixedInputs := make([][]*Value, len(inputs))
+	mixedLabels := make([][]*Value, len(labels))
+	for i, j := range perm {
+		mixedInputs[i] = blend(inputs[i], inputs[j])
+		mixedLabels[i] = blend(labels[i], labels[j])
+	}
+	if weights := trainingParam.SampleWeights; weights != nil {
+		mixed := make([]float64, len(weights))
+		for i, j := range perm {
+			mixed[i] = lambda*weights[i] + (1-lambda)*weights[j]
+		}
+		trainingParam.SampleWeights = mixed
+	}
+	return mixedInputs, mixedLabels, trainingParam
+}
+
+// Draws a number from the beta distribution Beta(a, b).
+func sampleBeta(a, b float64, rng *rand.Rand) float64 {
+	x, y := sampleGamma(a, rng), sampleGamma(b, rng)
+	return x / (x + y)
+}
+
+// Draws a number from the gamma distribution with shape a and scale 1 by the
+// method of Marsaglia and Tsang.
+func sampleGamma(a float64, rng *rand.Rand) float64 {
+	if a < 1.0 {
+		// Gamma(a) = Gamma(a+1) * U^(1/a)
+		return sampleGamma(a+1.0, rng) * math.Pow(rng.Float64(), 1.0/a)
+	}
+	d := a - 1.0/3.0
+	c := 1.0 / math.Sqrt(9.0*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1.0 + c*x
+		if v <= 0.0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
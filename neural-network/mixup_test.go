@@ -0,0 +1,85 @@
+package nn
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleBeta(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	for _, shape := range [][2]float64{{0.2, 0.2}, {1.0, 1.0}, {2.0, 5.0}} {
+		a, b := shape[0], shape[1]
+		n, sum := 10000, 0.0
+		for i := 0; i < n; i++ {
+			x := sampleBeta(a, b, rng)
+			assert.True(t, x >= 0.0 && x <= 1.0, "expected a number in [0, 1], got %f", x)
+			sum += x
+		}
+		expected := a / (a + b)
+		assert.InDelta(t, expected, sum/float64(n), 0.01, "expected mean %f, got %f", expected, sum/float64(n))
+	}
+}
+
+func TestMixup(t *testing.T) {
+	inputs := makeRecords([][]float64{{0.0, 1.0}, {1.0, 0.0}, {2.0, 2.0}})
+	labels := makeRecords([][]float64{{0.0}, {1.0}, {1.0}})
+	trainingParam := TrainingParam{MixupAlpha: 0.4, SampleWeights: []float64{1.0, 2.0, 3.0}, Rand: rand.New(rand.NewSource(seed))}
+	mixedInputs, mixedLabels, mixedParam := mixup(inputs, labels, trainingParam)
+
+	// Records are blended with the records of a permutation drawn after the
+	// weight, and inputs, labels and sample weights use the same weight.
+	rng := rand.New(rand.NewSource(seed))
+	lambda := sampleBeta(0.4, 0.4, rng)
+	blend := func(x, y float64) float64 { return lambda*x + (1-lambda)*y }
+	for i, j := range rng.Perm(len(inputs)) {
+		for k := range inputs[i] {
+			assert.InDelta(t, blend(inputs[i][k].GetData(), inputs[j][k].GetData()), mixedInputs[i][k].GetData(), 1e-12)
+		}
+		assert.InDelta(t, blend(labels[i][0].GetData(), labels[j][0].GetData()), mixedLabels[i][0].GetData(), 1e-12)
+		assert.InDelta(t, blend(trainingParam.SampleWeights[i], trainingParam.SampleWeights[j]), mixedParam.SampleWeights[i], 1e-12)
+	}
+	assert.Equal(t, []float64{1.0, 2.0, 3.0}, trainingParam.SampleWeights)
+	assert.Panics(t, func() { mixup(inputs, labels, TrainingParam{MixupAlpha: 0.4}) })
+}
+
+func TestMixupTraining(t *testing.T) {
+	lines := ReadCSV("../data/make_moon.csv")[1:101]
+	inputs := make([][]*Value, len(lines))
+	labels := make([][]*Value, len(lines))
+	for i, line := range lines {
+		input, label := getRecord(line)
+		inputs[i], labels[i] = input, []*Value{label}
+	}
+	layerParams := []LayerParam{MakeLayerParam(8, Tanh), MakeLayerParam(1, Sigmoid)}
+	train := func() ([]float64, [][]*Value, []float64) {
+		model := MakeNeuralNetwork(2, layerParams, rand.New(rand.NewSource(seed)))
+		trainingParam := TrainingParam{
+			Epochs:                  100,
+			LearningRate:            0.5,
+			ClassificationThreshold: 0.5,
+			MixupAlpha:              0.2,
+			LabelSmoothing:          0.1,
+			Rand:                    rand.New(rand.NewSource(seed)),
+		}
+		return model.Train(inputs, labels, trainingParam)
+	}
+
+	// Training with the same seed is deterministic.
+	losses, scores, _ := train()
+	again, _, _ := train()
+	assert.Equal(t, losses, again)
+	assert.Equal(t, len(inputs), len(scores))
+	mean := func(losses []float64) float64 {
+		sum := 0.0
+		for _, loss := range losses {
+			sum += loss
+		}
+		return sum / float64(len(losses))
+	}
+	first, last := mean(losses[:10]), mean(losses[len(losses)-10:])
+	assert.Less(t, last, first, "expected loss to decrease from %f, got %f", first, last)
+	accuracy := Accuracy(scores, labels, TrainingParam{ClassificationThreshold: 0.5})
+	assert.Greater(t, accuracy, 0.75, "expected accuracy > %f, got %f", 0.75, accuracy)
+}
@@ -0,0 +1,288 @@
+package nn
+
+import "fmt"
+
+// A module is a building block of a neural network which computes output
+// values from input values using its trainable parameters. Layer implements
+// Module, and custom layer types implementing it can be combined with
+// Sequential or MakeModel. Training, regularization and persistence only see
+// a module through its Parameters.
+type Module interface {
+	// Computes the output values of the module given the input values.
+	Forward(input []*Value) []*Value
+	// Returns the trainable parameters of the module. The same parameters must
+	// be returned in the same order on every call.
+	Parameters() []*Value
+}
+
+// A module applying multiple modules one after another.
+type Sequential struct {
+	modules []Module
+}
+
+// Makes a Sequential module applying the given modules in order.
+func MakeSequential(modules ...Module) *Sequential {
+	return &Sequential{
+		modules: modules,
+	}
+}
+
+// Computes the output of the last module fed by the output of the previous
+// modules.
+func (s *Sequential) Forward(input []*Value) []*Value {
+	return forward(s.modules, input)
+}
+
+// Returns the parameters of all modules in order.
+func (s *Sequential) Parameters() []*Value {
+	return parameters(s.modules)
+}
+
+// Returns the modules of the Sequential module.
+func (s *Sequential) Modules() []Module {
+	return s.modules
+}
+
+func forward(modules []Module, input []*Value) []*Value {
+	ans := input
+	for _, module := range modules {
+		ans = module.Forward(ans)
+	}
+	return ans
+}
+
+func parameters(modules []Module) []*Value {
+	params := []*Value{}
+	for _, module := range modules {
+		params = append(params, module.Parameters()...)
+	}
+	return params
+}
+
+// Modules behaving differently in training and inference, e.g. Dropout,
+// implement TrainingMode. NeuralNetwork switches the mode of its modules
+// during Train.
+type TrainingMode interface {
+	// Switches the module to training mode if training is true, and to
+	// inference (evaluation) mode otherwise.
+	SetTraining(training bool)
+}
+
+// Switches the mode of all modules which support it.
+func (s *Sequential) SetTraining(training bool) {
+	setTraining(s.modules, training)
+}
+
+func setTraining(modules []Module, training bool) {
+	for _, module := range modules {
+		if m, ok := module.(TrainingMode); ok {
+			m.SetTraining(training)
+		}
+	}
+}
+
+// Modules which need all records of a batch at once, e.g. BatchNorm,
+// implement BatchModule. NeuralNetwork.Forward calls ForwardBatch instead of
+// calling Forward for each record.
+type BatchModule interface {
+	// Computes the output values of all records of a batch.
+	ForwardBatch(inputs [][]*Value) [][]*Value
+}
+
+// Computes the outputs of all records of a batch.
+func (s *Sequential) ForwardBatch(inputs [][]*Value) [][]*Value {
+	return forwardBatch(s.modules, inputs)
+}
+
+// Applies modules one after another on all records, calling ForwardBatch of
+// the modules which implement BatchModule.
+func forwardBatch(modules []Module, inputs [][]*Value) [][]*Value {
+	ans := inputs
+	for _, module := range modules {
+		if m, ok := module.(BatchModule); ok {
+			ans = m.ForwardBatch(ans)
+			continue
+		}
+		outputs := make([][]*Value, len(ans))
+		for i, input := range ans {
+			outputs[i] = module.Forward(input)
+		}
+		ans = outputs
+	}
+	return ans
+}
+
+// Modules having values which are not trained but are part of the model,
+// e.g. running statistics of BatchNorm, implement Stateful so the values are
+// saved and loaded with the parameters.
+type Stateful interface {
+	// Returns the state values of the module. The same values must be
+	// returned in the same order on every call.
+	State() []*Value
+}
+
+// Returns the parameters of a module followed by its state.
+func persisted(module Module) []*Value {
+	values := module.Parameters()
+	if m, ok := module.(Stateful); ok {
+		values = append(values, m.State()...)
+	}
+	return values
+}
+
+// Returns the state of all modules implementing Stateful in order.
+func (s *Sequential) State() []*Value {
+	state := []*Value{}
+	for _, module := range s.modules {
+		if m, ok := module.(Stateful); ok {
+			state = append(state, m.State()...)
+		}
+	}
+	return state
+}
+
+// Modules whose forward passes only use some of their parameters, e.g.
+// Embedding, implement SparseModule so the optimizer step only updates the
+// parameters which were used.
+type SparseModule interface {
+	// Returns the parameters used by forward passes since the last call of
+	// ClearUsed.
+	UsedParameters() []*Value
+	// Forgets the used parameters. It's called after each optimizer step.
+	ClearUsed()
+}
+
+// Returns the parameters of all modules, only including the used parameters
+// of modules implementing SparseModule.
+func (s *Sequential) UsedParameters() []*Value {
+	return usedParameters(s.modules)
+}
+
+// Forgets the used parameters of all modules implementing SparseModule.
+func (s *Sequential) ClearUsed() {
+	clearUsed(s.modules)
+}
+
+func usedParameters(modules []Module) []*Value {
+	params := []*Value{}
+	for _, module := range modules {
+		if m, ok := module.(SparseModule); ok {
+			params = append(params, m.UsedParameters()...)
+		} else {
+			params = append(params, module.Parameters()...)
+		}
+	}
+	return params
+}
+
+func clearUsed(modules []Module) {
+	for _, module := range modules {
+		if m, ok := module.(SparseModule); ok {
+			m.ClearUsed()
+		}
+	}
+}
+
+// Modules tracking running statistics of their inputs, e.g. BatchNorm,
+// implement StatisticsModule. Forward passes in training mode collect the
+// statistics of their batches, and the running statistics are updated once per
+// optimizer step.
+type StatisticsModule interface {
+	// Updates the running statistics by the statistics collected since the
+	// last update, and forgets them. It's called after each optimizer step.
+	UpdateStatistics()
+	// Forgets the collected statistics. It's called after forward passes
+	// which only re-evaluate the loss.
+	DiscardStatistics()
+}
+
+// Updates the running statistics of all modules implementing
+// StatisticsModule.
+func (s *Sequential) UpdateStatistics() {
+	updateStatistics(s.modules)
+}
+
+// Forgets the collected statistics of all modules implementing
+// StatisticsModule.
+func (s *Sequential) DiscardStatistics() {
+	discardStatistics(s.modules)
+}
+
+func updateStatistics(modules []Module) {
+	for _, module := range modules {
+		if m, ok := module.(StatisticsModule); ok {
+			m.UpdateStatistics()
+		}
+	}
+}
+
+func discardStatistics(modules []Module) {
+	for _, module := range modules {
+		if m, ok := module.(StatisticsModule); ok {
+			m.DiscardStatistics()
+		}
+	}
+}
+
+// Returns the trainable values.
+func trainable(values []*Value) []*Value {
+	ans := []*Value{}
+	for _, value := range values {
+		if value.Trainable() {
+			ans = append(ans, value)
+		}
+	}
+	return ans
+}
+
+// Modules owning parameters or state implement Cloneable so models can be
+// copied. Modules without parameters and state which don't implement it are
+// shared by the copies.
+type Cloneable interface {
+	// Returns a deep copy of the module which shares no values with it.
+	Clone() Module
+}
+
+// Returns a deep copy of a module. It panics if the module has parameters or
+// state but does not implement Cloneable.
+func cloneModule(module Module) Module {
+	if m, ok := module.(Cloneable); ok {
+		return m.Clone()
+	}
+	if len(persisted(module)) > 0 {
+		panic(fmt.Sprintf("cannot clone module of type %T", module))
+	}
+	return module
+}
+
+func cloneModules(modules []Module) []Module {
+	ans := make([]Module, len(modules))
+	for i, module := range modules {
+		ans[i] = cloneModule(module)
+	}
+	return ans
+}
+
+// Returns new leaf values with the data and trainable flags of values.
+func copyValues(values []*Value) []*Value {
+	ans := make([]*Value, len(values))
+	for i, value := range values {
+		ans[i] = MakeValue(value.data)
+		ans[i].frozen = value.frozen
+	}
+	return ans
+}
+
+// Copies the data and trainable flags of values into other values of the
+// same length.
+func assignValues(dst, src []*Value) {
+	for i, value := range src {
+		dst[i].data = value.data
+		dst[i].frozen = value.frozen
+	}
+}
+
+// Returns a copy of the modules.
+func (s *Sequential) Clone() Module {
+	return MakeSequential(cloneModules(s.modules)...)
+}
@@ -0,0 +1,167 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A custom module computing y_i = a*x_i + b.
+type affine struct {
+	a, b *Value
+}
+
+func (m *affine) Forward(input []*Value) []*Value {
+	ans := make([]*Value, len(input))
+	for i, x := range input {
+		ans[i] = m.a.Mul(x).Add(m.b)
+	}
+	return ans
+}
+
+func (m *affine) Parameters() []*Value {
+	return []*Value{m.a, m.b}
+}
+
+// A custom module without parameters.
+type activation func([]*Value) []*Value
+
+func (m activation) Forward(input []*Value) []*Value {
+	return m(input)
+}
+
+func (m activation) Parameters() []*Value {
+	return nil
+}
+
+// Makes a module applying f to each input value.
+func elementwiseModule(f func(*Value) *Value) Module {
+	return activation(elementwise(f))
+}
+
+func TestSequential(t *testing.T) {
+	first := &affine{MakeValue(2.0), MakeValue(1.0)}
+	second := &affine{MakeValue(-1.0), MakeValue(0.5)}
+	module := MakeSequential(first, second)
+
+	output := module.Forward([]*Value{MakeValue(3.0)})
+	assert.Equal(t, 1, len(output), "expected %d, got %d", 1, len(output))
+	// -(2*3 + 1) + 0.5
+	assert.Equalf(t, -6.5, output[0].GetData(), "expected %f, got %f", -6.5, output[0].GetData())
+	assert.Equal(t, []*Value{first.a, first.b, second.a, second.b}, module.Parameters())
+	assert.Equal(t, []Module{first, second}, module.Modules())
+}
+
+func TestCustomModule(t *testing.T) {
+	scale := &affine{MakeValue(0.1), MakeValue(0.0)}
+	model := MakeModel(MakeSequential(scale, activation(elementwise(Sigmoid))))
+	inputs := [][]*Value{{MakeValue(-2.0)}, {MakeValue(-1.0)}, {MakeValue(1.0)}, {MakeValue(2.0)}}
+	labels := [][]*Value{{MakeValue(0.0)}, {MakeValue(0.0)}, {MakeValue(1.0)}, {MakeValue(1.0)}}
+
+	trainingParam := TrainingParam{
+		Epochs:         50,
+		Regularization: 0.001,
+		LearningRate:   0.5,
+	}
+	losses, _, _ := model.Train(inputs, labels, trainingParam)
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+	assert.Greater(t, scale.a.GetData(), 1.0, "expected %f to be trained", scale.a.GetData())
+
+	filename := t.TempDir() + "/model.csv"
+	assert.NoError(t, model.Save(filename))
+	loaded := &affine{MakeValue(0.0), MakeValue(0.0)}
+	assert.NoError(t, MakeModel(loaded).Load(filename))
+	assert.Equalf(t, scale.a.GetData(), loaded.a.GetData(), "expected %f, got %f", scale.a.GetData(), loaded.a.GetData())
+	assert.Equalf(t, scale.b.GetData(), loaded.b.GetData(), "expected %f, got %f", scale.b.GetData(), loaded.b.GetData())
+}
+
+// Checks the gradient of loss with respect to values against central finite
+// differences.
+func checkGradient(t *testing.T, values []*Value, loss func() *Value) {
+	const eps = 1e-6
+	grads := Gradient(values, loss)
+	for i, value := range values {
+		data := value.data
+		value.data = data + eps
+		pos := loss().data
+		value.data = data - eps
+		neg := loss().data
+		value.data = data
+		expected := (pos - neg) / (2 * eps)
+		assert.InDelta(t, expected, grads[i], 1e-5, "value %d: expected %f, got %f", i, expected, grads[i])
+	}
+}
+
+// Returns a weighted sum of outputs with fixed weights, so the gradient of
+// every output is checked.
+func weightedSum(outputs ...[]*Value) *Value {
+	ans := MakeValue(0.0)
+	k := 0
+	for _, output := range outputs {
+		for _, y := range output {
+			k++
+			ans = ans.Add(y.Mul(MakeValue(math.Sin(float64(k)))))
+		}
+	}
+	return ans
+}
+
+// Makes records from data.
+func makeRecords(data [][]float64) [][]*Value {
+	records := make([][]*Value, len(data))
+	for i, row := range data {
+		records[i] = make([]*Value, len(row))
+		for j, x := range row {
+			records[i][j] = MakeValue(x)
+		}
+	}
+	return records
+}
+
+func TestCloneModules(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	graph := MakeGraph()
+	x := graph.Input("x", 4)
+	shared := MakeLayer(4, MakeLayerParam(4, Tanh), rng)
+	graph.Output("y", graph.Apply(shared, graph.Apply(shared, x)))
+	batchNorm := MakeBatchNorm(4, 0.5)
+	batchNorm.ForwardBatch([][]*Value{makeInput(4), makeRecords([][]float64{{1.0, 2.0, 3.0, 4.0}})[0]})
+	modules := map[string]Module{
+		"Layer":              MakeLayer(4, MakeLearnableLayerParam(2, PRelu(0.25)), rng),
+		"Sequential":         MakeSequential(MakeLayer(4, MakeLayerParam(3, Relu), rng), elementwiseModule(Tanh)),
+		"BatchNorm":          batchNorm,
+		"LayerNorm":          MakeLayerNorm(4),
+		"Embedding":          MakeEmbedding(4, 2, rng),
+		"Conv1D":             MakeConv1D(ConvParam{InChannels: 2, OutChannels: 2, KernelSize: 2}, rng),
+		"Conv2D":             MakeConv2D(2, 2, ConvParam{InChannels: 1, OutChannels: 2, KernelSize: 2}, rng),
+		"RNN":                MakeRNN(MakeRNNCell(2, 3, rng), 2, true),
+		"LSTM":               MakeRNN(MakeLSTMCell(2, 3, rng), 2, false),
+		"GRU":                MakeRNN(MakeGRUCell(2, 3, rng), 2, false),
+		"MultiHeadAttention": MakeMultiHeadAttention(2, 2, true, rng),
+		"TransformerBlock":   MakeTransformerBlock(2, 1, 3, false, rng),
+		"Graph":              graph,
+	}
+	for name, module := range modules {
+		module.Parameters()[0].SetTrainable(false)
+		clone := cloneModule(module)
+		input := makeRecords([][]float64{{0.0, 1.0, 3.0, 2.0}})[0]
+		expected, actual := module.Forward(input), clone.Forward(input)
+		for i := range expected {
+			assert.Equal(t, expected[i].GetData(), actual[i].GetData(), "%s: expected %f, got %f", name, expected[i].GetData(), actual[i].GetData())
+		}
+
+		values, cloned := persisted(module), persisted(clone)
+		assert.Equal(t, len(values), len(cloned), "%s: expected %d, got %d", name, len(values), len(cloned))
+		for i := range values {
+			assert.NotSame(t, values[i], cloned[i], name)
+			assert.Equal(t, values[i].GetData(), cloned[i].GetData(), "%s: expected %f, got %f", name, values[i].GetData(), cloned[i].GetData())
+			assert.Equal(t, values[i].Trainable(), cloned[i].Trainable(), name)
+		}
+	}
+
+	// Modules with parameters must implement Cloneable.
+	assert.NotNil(t, cloneModule(elementwiseModule(Tanh)))
+	assert.Panics(t, func() { cloneModule(&affine{MakeValue(1.0), MakeValue(0.0)}) })
+}
@@ -1,13 +1,28 @@
 package nn
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
+	"sync"
 )
 
-// A neural network object consitsting of multiple layers.
+// A neural network object consitsting of multiple modules applied one after
+// another, e.g. layers.
+//
+// Fit and Forward don't change the parameters, so they can be called from
+// multiple goroutines. Some modules record state during forward passes, e.g.
+// the used rows of Embedding and the batch statistics of BatchNorm, and guard
+// it themselves. Each goroutine should compute gradients of its own
+// loss with Value.Backward and merge them with AddGrad, which is safe for
+// concurrent use. Parameters must not be updated (e.g. by NextData) while
+// forward or backward passes are running.
 type NeuralNetwork struct {
-	layers []*Layer
+	modules []Module
+	// Whether the network is in training mode.
+	training bool
+	// Guards grad of the parameters when merging gradient buffers.
+	mu sync.Mutex
 }
 
 // A neuron object with parameters w_1, ..., w_n, b.
@@ -17,16 +32,25 @@ type Neuron struct {
 }
 
 // Makes a neuron with a given inputSize. A neuron has inputSize+1 parameters.
-// The intercept is initialized to 0 and weights are initialized to random numbers
-// in [-1, 1).
-func MakeNeuron(inputSize int) *Neuron {
-	weights := make([]*Value, inputSize)
+// The weights and the intercept are drawn from the standard normal distribution
+// using rng.
+func MakeNeuron(inputSize int, rng *rand.Rand) *Neuron {
+	weights := make([]float64, inputSize)
 	for i := range weights {
-		weights[i] = MakeValue(rand.NormFloat64())
+		weights[i] = rng.NormFloat64()
+	}
+	return makeNeuron(weights, rng.NormFloat64())
+}
+
+// Makes a neuron with given initial weights and intercept.
+func makeNeuron(weights []float64, intercept float64) *Neuron {
+	values := make([]*Value, len(weights))
+	for i, w := range weights {
+		values[i] = MakeValue(w)
 	}
 	return &Neuron{
-		intercept: MakeValue(rand.NormFloat64()),
-		weights:   weights,
+		intercept: MakeValue(intercept),
+		weights:   values,
 	}
 }
 
@@ -40,39 +64,147 @@ func (n *Neuron) Fit(input []*Value) *Value {
 	return ans
 }
 
+// Returns copies of neurons with new parameters of the same values.
+func cloneNeurons(neurons []*Neuron) []*Neuron {
+	ans := make([]*Neuron, len(neurons))
+	for i, neuron := range neurons {
+		values := copyValues(append([]*Value{neuron.intercept}, neuron.weights...))
+		ans[i] = &Neuron{intercept: values[0], weights: values[1:]}
+	}
+	return ans
+}
+
 // Parameters of a layer: outputSize AKA the number of neurons in the layer.
 // Each layer can have a different activation function.
 type LayerParam struct {
 	outputSize int
-	// The number of activation functions must match the number of
-	activation func(*Value) *Value
+	// Activation of all outputs of the layer and its name.
+	activation func([]*Value) []*Value
+	name       string
+	// Makes an activation with trainable parameters for the layer.
+	learnable func(outputSize int) LearnableActivation
+	// Initializers of the weights and intercepts. Both are standard normal
+	// if neither is set; otherwise weights are standard normal and intercepts
+	// zeros if nil.
+	weightInit    Initializer
+	interceptInit Initializer
 }
 
 // Makes a LayerParam object with a given outputSize (number of neurons) and
 // an activation function.
 func MakeLayerParam(outputSize int, activation func(*Value) *Value) LayerParam {
+	layerParam := LayerParam{
+		outputSize: outputSize,
+		name:       activationName(activation),
+	}
+	if activation != nil {
+		layerParam.activation = elementwise(activation)
+	}
+	return layerParam
+}
+
+// Makes a LayerParam object with a given outputSize (number of neurons) and
+// an activation function of all outputs of the layer such as Softmax.
+func MakeVectorLayerParam(outputSize int, activation func([]*Value) []*Value) LayerParam {
 	return LayerParam{
 		outputSize: outputSize,
 		activation: activation,
+		name:       activationName(activation),
+	}
+}
+
+// Makes a LayerParam object with a given outputSize (number of neurons) and
+// an activation function with trainable parameters such as PRelu or Swish.
+func MakeLearnableLayerParam(outputSize int, activation func(outputSize int) LearnableActivation) LayerParam {
+	return LayerParam{
+		outputSize: outputSize,
+		learnable:  activation,
+		name:       activationName(activation),
 	}
 }
 
+// Returns a copy of the LayerParam naming its activation in the summary of
+// the network. Activations of this package are named by default, e.g. "ReLU",
+// so it's needed for custom activations.
+func (p LayerParam) WithName(name string) LayerParam {
+	p.name = name
+	return p
+}
+
+// Returns a copy of the LayerParam initializing the weights and intercepts of
+// the layer by given initializers. Intercepts are initialized as the weights
+// of neurons with a single input, and are zeros if intercepts is nil. Weights
+// are drawn from the standard normal distribution if weights is nil.
+func (p LayerParam) WithInitializers(weights, intercepts Initializer) LayerParam {
+	p.weightInit = weights
+	p.interceptInit = intercepts
+	return p
+}
+
 // A layer object consisting of multiple neurons.
 type Layer struct {
 	neurons    []*Neuron
-	activation func(*Value) *Value
+	activation func([]*Value) []*Value
+	learnable  LearnableActivation
+	// Makes the learnable activation of a clone.
+	makeLearnable func(outputSize int) LearnableActivation
+	// Name of the activation.
+	name string
 }
 
-// Makes a layer consisting of multiple neurons.
-func MakeLayer(inputSize int, layerParam LayerParam) *Layer {
+// Makes a layer consisting of multiple neurons with parameters initialized
+// using rng.
+func MakeLayer(inputSize int, layerParam LayerParam, rng *rand.Rand) *Layer {
 	neurons := make([]*Neuron, layerParam.outputSize)
-	for i := range neurons {
-		neurons[i] = MakeNeuron(inputSize)
+	if layerParam.weightInit == nil && layerParam.interceptInit == nil {
+		for i := range neurons {
+			neurons[i] = MakeNeuron(inputSize, rng)
+		}
+	} else {
+		weightInit, interceptInit := layerParam.weightInit, layerParam.interceptInit
+		if weightInit == nil {
+			weightInit = Normal(1.0)
+		}
+		if interceptInit == nil {
+			interceptInit = Zeros
+		}
+		weights := weightInit(inputSize, layerParam.outputSize, rng)
+		intercepts := interceptInit(1, layerParam.outputSize, rng)
+		for i := range neurons {
+			neurons[i] = makeNeuron(weights[i], intercepts[i][0])
+		}
 	}
-	return &Layer{
+	layer := &Layer{
 		neurons:    neurons,
 		activation: layerParam.activation,
+		name:       layerParam.name,
+	}
+	if layerParam.learnable != nil {
+		layer.makeLearnable = layerParam.learnable
+		layer.learnable = layerParam.learnable(layerParam.outputSize)
+		layer.activation = layer.learnable.Fit
 	}
+	return layer
+}
+
+func cloneLayer(l *Layer) *Layer {
+	return l.Clone().(*Layer)
+}
+
+// Returns a copy of the layer with new parameters of the same values.
+func (l *Layer) Clone() Module {
+	layer := &Layer{
+		neurons:       cloneNeurons(l.neurons),
+		activation:    l.activation,
+		makeLearnable: l.makeLearnable,
+		name:          l.name,
+	}
+	if l.learnable != nil {
+		layer.learnable = l.makeLearnable(len(l.neurons))
+		layer.activation = layer.learnable.Fit
+		assignValues(layer.learnable.Parameters(), l.learnable.Parameters())
+	}
+	return layer
 }
 
 // Computes all output values of the layer given the input values and an
@@ -84,166 +216,526 @@ func (l *Layer) Fit(input []*Value) []*Value {
 	}
 	// Fit activation if given.
 	if l.activation != nil {
-		for i := range ans {
-			ans[i] = l.activation(ans[i])
-		}
+		ans = l.activation(ans)
 	}
 	return ans
 }
 
-// Makes a neural network consisting of multiple layers.
-func MakeNeuralNetwork(inputSize int, layerParams []LayerParam) *NeuralNetwork {
-	layers := make([]*Layer, len(layerParams))
+// Computes all output values of the layer. Same as Fit.
+func (l *Layer) Forward(input []*Value) []*Value {
+	return l.Fit(input)
+}
+
+// Returns the neurons of the layer.
+func (l *Layer) Neurons() []*Neuron {
+	return l.neurons
+}
+
+// Returns the number of inputs of the layer.
+func (l *Layer) InputSize() int {
+	if len(l.neurons) == 0 {
+		return 0
+	}
+	return len(l.neurons[0].weights)
+}
+
+// Returns the number of outputs of the layer.
+func (l *Layer) OutputSize() int {
+	return len(l.neurons)
+}
+
+// Returns all parameters of the layer including the parameters of its
+// activation.
+func (l *Layer) Parameters() []*Value {
+	params := []*Value{}
+	for _, neuron := range l.neurons {
+		params = append(params, neuron.intercept)
+		params = append(params, neuron.weights...)
+	}
+	if l.learnable != nil {
+		params = append(params, l.learnable.Parameters()...)
+	}
+	return params
+}
+
+// Freezes or unfreezes all parameters of the layer including the parameters
+// of its activation, e.g. to fine-tune only some layers of a trained network.
+func (l *Layer) SetTrainable(trainable bool) {
+	for _, param := range l.Parameters() {
+		param.SetTrainable(trainable)
+	}
+}
+
+// Returns whether any parameter of the layer is trainable.
+func (l *Layer) Trainable() bool {
+	return len(trainable(l.Parameters())) > 0
+}
+
+// Makes a neural network consisting of multiple layers with parameters
+// initialized using rng.
+func MakeNeuralNetwork(inputSize int, layerParams []LayerParam, rng *rand.Rand) *NeuralNetwork {
+	modules := make([]Module, len(layerParams))
 	for i, layerParam := range layerParams {
-		layers[i] = MakeLayer(inputSize, layerParam)
+		modules[i] = MakeLayer(inputSize, layerParam, rng)
 		inputSize = layerParam.outputSize
 	}
+	return MakeModel(modules...)
+}
+
+// Makes a neural network consisting of multiple modules applied one after
+// another.
+func MakeModel(modules ...Module) *NeuralNetwork {
 	return &NeuralNetwork{
-		layers: layers,
+		modules: modules,
 	}
 }
 
 // Fits the model on input data and return the score.
 func (n *NeuralNetwork) Fit(input []*Value) []*Value {
-	ans := input
-	for _, layer := range n.layers {
-		ans = layer.Fit(ans)
-	}
-	return ans
+	return forward(n.modules, input)
 }
 
-// Computes scores of all input data.
-func (n *NeuralNetwork) Forward(inputs [][]*Value) [][]*Value {
-	scores := make([][]*Value, len(inputs))
-	for i, input := range inputs {
-		scores[i] = n.Fit(input)
-	}
-	return scores
+// Switches the network to training mode if training is true, and to
+// inference mode otherwise. The mode only affects modules implementing
+// TrainingMode. Train switches to training mode and back to inference mode
+// when done.
+func (n *NeuralNetwork) SetTraining(training bool) {
+	n.training = training
+	setTraining(n.modules, training)
 }
 
-// Computes the loss as a Value object which is minimized in the optimization
-// process when traininng the model.
-func (n *NeuralNetwork) Loss(labels, scores [][]*Value, trainingParam TrainingParam) *Value {
-	floatNumRecords := float64(len(scores))
-	// Initializing loss = 1/batchSize. Will update loss in the following loop.
-	loss := MakeValue(0.0)
+// Returns true if the network is in training mode.
+func (n *NeuralNetwork) Training() bool {
+	return n.training
+}
 
-	for i := range scores {
-		// Hinge loss: loss += Relu(1 - label * score) where label is in {-1, 1}
-		// loss = loss.Add(Relu(MakeValue(1.0).Sub(labels[i].Mul(score[0]))))
-		normalize(scores[i])
-		for j, score := range scores[i] {
-			// cross-entropy loss
-			label := labels[i][j]
-			pos := label.Mul(score.Log())
-			neg := MakeValue(1).Sub(label).Mul(MakeValue(1).Sub(score).Log())
-			loss = loss.Sub(pos.Add(neg))
-		}
-	}
-	// accuracy /= floatNumRecords
-	loss = loss.Div(MakeValue(floatNumRecords))
-
-	regularizationParam := trainingParam.Regularization
-	if regularizationParam > 0.0 {
-		// Regularization term
-		norm2Loss := MakeValue(0.0)
-		for _, layer := range n.layers {
-			for _, neuron := range layer.neurons {
-				norm2Loss = norm2Loss.Add(neuron.intercept.Pow(2))
-				for _, weight := range neuron.weights {
-					norm2Loss = norm2Loss.Add(weight.Pow(2))
-				}
-			}
-		}
-		norm2Loss = norm2Loss.Mul(MakeValue(regularizationParam))
-		loss = loss.Add(norm2Loss)
+// Computes scores of all input data in inference mode.
+func (n *NeuralNetwork) Predict(inputs [][]*Value) [][]*Value {
+	if n.training {
+		n.SetTraining(false)
+		defer n.SetTraining(true)
 	}
-	return loss
+	return n.Forward(inputs)
 }
 
-func normalize(score []*Value) {
-	if len(score) < 2 {
-		return
-	}
-	sum, max := MakeValue(0.0), getMax(score)
-	for i := range score {
-		score[i] = score[i].Sub(max).Exp()
-		sum = sum.Add(score[i])
-	}
-	for i := range score {
-		score[i] = score[i].Div(sum)
-	}
+// Computes scores of all input data. Modules implementing BatchModule see
+// all records at once.
+func (n *NeuralNetwork) Forward(inputs [][]*Value) [][]*Value {
+	return forwardBatch(n.modules, inputs)
 }
 
-func getMax(score []*Value) *Value {
-	out := MakeValue(math.MinInt64)
-	for _, s := range score {
-		if s.data > out.data {
-			*out = *s
+// Computes the loss as a Value object which is minimized in the optimization
+// process when traininng the model. The loss function is given by
+// trainingParam, and defaults to binary cross-entropy for networks with a
+// single output, categorical cross-entropy for outputs summing to 1, e.g. of
+// Softmax, and the mean binary cross-entropy of the outputs for multi-label
+// outputs, e.g. of Sigmoid. If sample or class
+// weights are given, the loss is the weighted mean of the losses of the
+// records. Labels of classification losses are smoothed by LabelSmoothing if
+// set.
+func (n *NeuralNetwork) Loss(labels, scores [][]*Value, trainingParam TrainingParam) *Value {
+	lossFunc := trainingParam.LossFunc
+	if lossFunc == nil {
+		lossFunc = defaultLoss
+	}
+	weights := recordWeights(labels, trainingParam)
+	if epsilon := trainingParam.LabelSmoothing; epsilon > 0.0 {
+		if _, ok := lossFunc.(classificationLoss); !ok {
+			panic("LabelSmoothing requires a classification loss: cross-entropy, KL divergence or focal loss")
 		}
+		smoothed := make([][]*Value, len(labels))
+		for i, label := range labels {
+			smoothed[i] = smoothLabels(label, epsilon)
+		}
+		labels = smoothed
+	}
+	losses := make([]*Value, len(scores))
+	for i := range scores {
+		losses[i] = lossFunc.Loss(labels[i], scores[i])
+	}
+	loss := weightedMean(losses, weights)
+	if penalty := n.regularization(trainingParam); penalty != nil {
+		loss = loss.Add(penalty)
 	}
-	return out
+	return loss
 }
 
 // TrainingParam holds parameters required for training the network.
 type TrainingParam struct {
-	Epochs                  int
-	Regularization          float64
+	Epochs int
+	// Strengths of the L2 penalty sum(w^2) and the L1 penalty sum(|w|) of
+	// the trainable parameters added to the loss. Setting both is elastic
+	// net regularization. Modules implementing SparseModule, e.g. Embedding,
+	// only contribute the parameters used by the forward passes.
+	Regularization   float64
+	L1Regularization float64
+	// Decoupled weight decay: each step also moves the parameters by
+	// -LearningRate*WeightDecay*w without adding to the loss. HessianFree
+	// has no learning rate and ignores it; Regularization adds the
+	// equivalent penalty to the loss.
+	WeightDecay float64
+	// Scales the regularization strengths and weight decay of the parameters
+	// of given modules, e.g. 0 excludes a layer. If modules overlap, the
+	// scale of the module with fewer parameters is used. Modules with the same
+	// parameters, e.g. a Sequential of a single Layer, must have equal scales.
+	RegularizationScales map[Module]float64
+	// Excludes the intercepts of neurons from regularization and weight
+	// decay.
+	ExcludeIntercepts bool
+	// If positive, the weights of each neuron are rescaled after each step so
+	// their norm is at most MaxNorm.
+	MaxNorm                 float64
 	ClassificationThreshold float64
 	LearningRate            float64
+	// Sets the learning rate of each step given LearningRate. The learning
+	// rate is constant if nil.
+	Scheduler Scheduler
+	// Records whose loss is computed after each epoch of Train, e.g. for
+	// ReduceOnPlateau.
+	ValidationInputs, ValidationLabels [][]*Value
+	// Loss function of each record, see NeuralNetwork.Loss for the default.
+	LossFunc LossFunc
+	// If positive, classification labels are smoothed towards the uniform
+	// distribution by y*(1-LabelSmoothing) + LabelSmoothing/k for k classes
+	// in the loss. Only cross-entropy, KL divergence and focal losses
+	// support it.
+	LabelSmoothing float64
+	// If positive, Train blends the records of each epoch with the records
+	// of a random permutation by weights drawn from Beta(MixupAlpha,
+	// MixupAlpha) using Rand.
+	MixupAlpha float64
+	// Source of random numbers for training, e.g. of mixup.
+	Rand *rand.Rand
+	// Weight of each record in losses and metrics, e.g. read by
+	// GetWeightedRecords. All records have weight 1 if nil.
+	SampleWeights []float64
+	// Weight of each class in losses and metrics, multiplied by the sample
+	// weights. Classes of binary labels are 0 and 1, see classOf.
+	ClassWeights []float64
+	// If set and ClassWeights is nil, classes are weighted inversely
+	// proportional to their frequencies in the labels, see
+	// BalancedClassWeights. Training methods compute the weights once from
+	// all training labels.
+	BalanceClasses bool
+	// Updates the parameters given LearningRate, e.g. MakeAdam(). The
+	// parameters are updated by gradient descent if nil.
+	Optimizer Optimizer
+	// If positive, gradients are clipped to [-ClipValue, ClipValue] and then
+	// rescaled so their global norm is at most ClipNorm before each step.
+	ClipValue, ClipNorm float64
+	// If more than 1, Train splits the records of each epoch into
+	// AccumulationSteps micro-batches and adds up their gradients before a
+	// step, which computes the gradients of the whole batch keeping only
+	// the graph of a micro-batch in memory. BatchModule modules, e.g.
+	// BatchNorm, see each micro-batch separately, so their gradients differ
+	// from those of the whole batch.
+	AccumulationSteps int
+	// If set, the network is trained with the Hessian-free optimizer instead
+	// of Optimizer, and LearningRate and WeightDecay are ignored. The
+	// learning rates returned by training are NaN. It doesn't support
+	// Dropout, see HessianFree. Training panics if AccumulationSteps,
+	// ClipValue or ClipNorm are set too, since they apply to gradient steps.
+	HessianFree *HessianFree
 }
 
-// Trains the network by minimizing the loss function
-func (n *NeuralNetwork) Train(inputs, labels [][]*Value, trainingParam TrainingParam) ([]float64, [][]*Value) {
+// Panics if HessianFree is set with options of gradient steps.
+func (t TrainingParam) validateHessianFree() {
+	if t.HessianFree != nil && (t.AccumulationSteps > 1 || t.ClipValue > 0.0 || t.ClipNorm > 0.0) {
+		panic("HessianFree does not support AccumulationSteps, ClipValue and ClipNorm")
+	}
+}
+
+// Trains the network by minimizing the loss function. The network is in
+// training mode while training and in inference mode afterwards. Returns the
+// loss of each epoch, the scores of the inputs in the last epoch and the
+// learning rate of each step. With mixup, the losses are of the blended
+// records. The scores are computed in training mode before the last step,
+// e.g. with dropout applied; Predict computes the scores of the trained
+// model.
+func (n *NeuralNetwork) Train(inputs, labels [][]*Value, trainingParam TrainingParam) ([]float64, [][]*Value, []float64) {
+	trainingParam.validateHessianFree()
+	n.SetTraining(true)
+	defer n.SetTraining(false)
+	trainingParam = balanceClasses(labels, trainingParam)
+
 	scores := [][]*Value{}
 	losses := make([]float64, trainingParam.Epochs)
+	learningRates := make([]float64, trainingParam.Epochs)
 	for i := 0; i < trainingParam.Epochs; i++ {
+		batchInputs, batchLabels, batchParam := inputs, labels, trainingParam
+		if trainingParam.MixupAlpha > 0.0 {
+			batchInputs, batchLabels, batchParam = mixup(inputs, labels, trainingParam)
+		}
+
+		if trainingParam.HessianFree != nil {
+			// HessianFree doesn't use a learning rate.
+			learningRates[i] = math.NaN()
+			scores = n.Forward(batchInputs)
+			loss := n.Loss(batchLabels, scores, batchParam)
+			losses[i] = loss.GetData()
+			// Re-evaluations of the loss don't update the running statistics.
+			updateStatistics(n.modules)
+			trainingParam.HessianFree.Step(trainable(n.Parameters()), loss, func() *Value {
+				return n.Loss(batchLabels, n.Forward(batchInputs), batchParam)
+			})
+			discardStatistics(n.modules)
+			n.constrain(trainingParam)
+		} else {
+			learningRates[i] = trainingParam.learningRateAt(i)
+			scores, losses[i] = n.accumulateGrad(batchInputs, batchLabels, batchParam)
+			n.step(trainingParam, learningRates[i])
+		}
+		trainingParam.observe(n.validationLoss(losses[i], trainingParam))
+	}
+	if trainingParam.MixupAlpha > 0.0 && trainingParam.Epochs > 0 {
 		scores = n.Forward(inputs)
-		loss := n.Loss(labels, scores, trainingParam)
-		losses[i] = loss.GetData()
+		discardStatistics(n.modules)
+	}
+	return losses, scores, learningRates
+}
+
+// Returns the loss of the validation records in inference mode, or the
+// training loss if there are none.
+func (n *NeuralNetwork) validationLoss(trainingLoss float64, trainingParam TrainingParam) float64 {
+	if trainingParam.ValidationInputs == nil {
+		return trainingLoss
+	}
+	// Sample weights are of the training records.
+	trainingParam.SampleWeights = nil
+	scores := n.Predict(trainingParam.ValidationInputs)
+	return n.Loss(trainingParam.ValidationLabels, scores, trainingParam).GetData()
+}
+
+// Returns all parameters of the network.
+func (n *NeuralNetwork) Parameters() []*Value {
+	return parameters(n.modules)
+}
+
+// Returns the modules of the network in order, e.g. to freeze some layers.
+func (n *NeuralNetwork) Modules() []Module {
+	return n.modules
+}
+
+// Returns a deep copy of the network sharing no parameters or state with it.
+// It panics if a module has parameters or state but does not implement
+// Cloneable.
+func (n *NeuralNetwork) Clone() *NeuralNetwork {
+	model := MakeModel(cloneModules(n.modules)...)
+	model.training = n.training
+	return model
+}
 
-		n.ResetGrad()
-		loss.BackPropagate()
+// Returns the values of all parameters of the network in the order of
+// Parameters, each shared parameter once. The state of modules, e.g. running
+// statistics of BatchNorm, is not included.
+func (n *NeuralNetwork) GetWeights() []float64 {
+	params := unique(n.Parameters())
+	weights := make([]float64, len(params))
+	for i, param := range params {
+		weights[i] = param.data
+	}
+	return weights
+}
 
-		n.NextData(trainingParam.LearningRate)
+// Sets the values of all parameters of the network from weights returned by
+// GetWeights of a network with the same architecture.
+func (n *NeuralNetwork) SetWeights(weights []float64) error {
+	params := unique(n.Parameters())
+	if len(weights) != len(params) {
+		return fmt.Errorf("expected %d weights, got %d", len(params), len(weights))
 	}
-	return losses, scores
+	for i, param := range params {
+		param.data = weights[i]
+	}
+	return nil
 }
 
-// Resets grad values of the entire network recursively.
-func (n *NeuralNetwork) ResetGrad() {
-	for _, layer := range n.layers {
-		for _, neuron := range layer.neurons {
-			neuron.intercept.grad = 0.0
-			for _, weight := range neuron.weights {
-				weight.grad = 0.0
+// Returns a copy of the first model whose parameters and state are the means
+// of those of all models, e.g. for model soups or federated averaging. The
+// models must have the same architecture.
+func Average(models ...*NeuralNetwork) (*NeuralNetwork, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no models to average")
+	}
+	ans := models[0].Clone()
+	for i, module := range ans.modules {
+		values := persisted(module)
+		sums := make([]float64, len(values))
+		for j, model := range models {
+			if len(model.modules) != len(ans.modules) {
+				return nil, fmt.Errorf("model %d: expected %d modules, got %d", j, len(ans.modules), len(model.modules))
+			}
+			other := persisted(model.modules[i])
+			if len(other) != len(values) {
+				return nil, fmt.Errorf("model %d: module %d: expected %d values, got %d", j, i, len(values), len(other))
 			}
+			for k, value := range other {
+				sums[k] += value.data
+			}
+		}
+		for k, value := range values {
+			value.data = sums[k] / float64(len(models))
 		}
 	}
+	return ans, nil
 }
 
-// Moves in the direction of the gradient descent and updates model.
-func (n *NeuralNetwork) NextData(learningRate float64) {
-	for _, layer := range n.layers {
-		for _, neuron := range layer.neurons {
-			neuron.intercept.data -= learningRate * neuron.intercept.grad
-			for _, weight := range neuron.weights {
-				weight.data -= learningRate * weight.grad
-			}
-		}
+// Resets grad values of the entire network recursively.
+func (n *NeuralNetwork) ResetGrad() {
+	for _, param := range n.Parameters() {
+		param.grad = 0.0
 	}
 }
 
+// Adds gradients of the network parameters from a buffer computed by
+// Value.Backward, once per shared parameter. It is safe for concurrent use.
+func (n *NeuralNetwork) AddGrad(grads Gradients) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, param := range unique(n.Parameters()) {
+		param.grad += grads[param]
+	}
+}
+
+// Moves in the direction of the gradient descent and updates model. Modules
+// implementing SparseModule only update the parameters used since the last
+// step, e.g. Embedding tracks them in training mode. Frozen parameters are not
+// updated. Gradients can be clipped before by
+// ClipValue or ClipNorm.
+func (n *NeuralNetwork) NextData(learningRate float64) {
+	n.step(TrainingParam{}, learningRate)
+}
+
+// Updates the model by an optimizer like NextData.
+func (n *NeuralNetwork) Step(optimizer Optimizer, learningRate float64) {
+	n.step(TrainingParam{Optimizer: optimizer}, learningRate)
+}
+
 // Computes the accuracy of a model given scores and labels. It also requires a
-// classification threshold.
+// classification threshold for binary classification. For multi-class
+// classification, the class with the highest score is predicted. If sample
+// or class weights are given, it is the weighted fraction of correct
+// predictions. Panics if the weights add up to zero.
 func Accuracy(scores, labels [][]*Value, trainingParam TrainingParam) (accuracy float64) {
 	threshold := trainingParam.ClassificationThreshold
+	weights := recordWeights(labels, trainingParam)
+	sum := 0.0
 	for i, score := range scores {
-		label := labels[i]
-		// label is 0.0 or 1.0, while score is in [0, 1] range.
-		if (label[0].GetData() > threshold) == (score[0].GetData() > threshold) {
-			accuracy++
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		sum += weight
+		if classOf(labels[i], threshold) == classOf(score, threshold) {
+			accuracy += weight
+		}
+	}
+	if sum == 0.0 {
+		panic("the weights of the records add up to zero")
+	}
+	return accuracy / sum
+}
+
+// Returns the class of a label or a score: the index of the largest value of
+// multiple values, and 1 if a single value is larger than threshold and 0
+// otherwise.
+func classOf(values []*Value, threshold float64) int {
+	if len(values) > 1 {
+		return argmax(values)
+	}
+	if values[0].data > threshold {
+		return 1
+	}
+	return 0
+}
+
+// Returns the class weights n/(k*n_c) of labels where n is the number of
+// labels, k the number of classes and n_c the number of labels of class c, so
+// every class has the same total weight. Classes without labels have weight 0.
+func BalancedClassWeights(labels [][]*Value, trainingParam TrainingParam) []float64 {
+	numClasses := 2
+	if len(labels) > 0 && len(labels[0]) > 1 {
+		numClasses = len(labels[0])
+	}
+	counts := make([]float64, numClasses)
+	for _, label := range labels {
+		counts[classOf(label, trainingParam.ClassificationThreshold)]++
+	}
+	weights := make([]float64, numClasses)
+	for c, count := range counts {
+		if count > 0 {
+			weights[c] = float64(len(labels)) / (float64(numClasses) * count)
+		}
+	}
+	return weights
+}
+
+// Returns trainingParam with the balanced class weights of labels if
+// BalanceClasses is set and ClassWeights is nil, so all batches of the
+// records share the class weights.
+func balanceClasses(labels [][]*Value, trainingParam TrainingParam) TrainingParam {
+	if trainingParam.ClassWeights == nil && trainingParam.BalanceClasses {
+		trainingParam.ClassWeights = BalancedClassWeights(labels, trainingParam)
+	}
+	return trainingParam
+}
+
+// Returns the weight of each record, i.e. its sample weight times the weight
+// of its class, or nil if no weights are given.
+func recordWeights(labels [][]*Value, trainingParam TrainingParam) []float64 {
+	trainingParam = balanceClasses(labels, trainingParam)
+	sampleWeights, classWeights := trainingParam.SampleWeights, trainingParam.ClassWeights
+	if sampleWeights == nil && classWeights == nil {
+		return nil
+	}
+	if sampleWeights != nil && len(sampleWeights) != len(labels) {
+		panic(fmt.Sprintf("expected %d sample weights, got %d", len(labels), len(sampleWeights)))
+	}
+	weights := make([]float64, len(labels))
+	for i, label := range labels {
+		weights[i] = 1.0
+		if sampleWeights != nil {
+			weights[i] = sampleWeights[i]
+		}
+		if classWeights != nil {
+			c := classOf(label, trainingParam.ClassificationThreshold)
+			if c >= len(classWeights) {
+				panic(fmt.Sprintf("missing weight of class %d", c))
+			}
+			weights[i] *= classWeights[c]
 		}
 	}
-	return accuracy / float64(len(scores))
+	return weights
+}
+
+// Returns the mean of the losses of records, weighted by weights unless it
+// is nil. Panics if the weights add up to zero.
+func weightedMean(losses []*Value, weights []float64) *Value {
+	ans := MakeValue(0.0)
+	if weights == nil {
+		for _, loss := range losses {
+			ans = ans.Add(loss)
+		}
+		return ans.Div(MakeValue(float64(len(losses))))
+	}
+	sum := 0.0
+	for i, loss := range losses {
+		ans = ans.Add(loss.Mul(MakeValue(weights[i])))
+		sum += weights[i]
+	}
+	if sum == 0.0 {
+		panic("the weights of the records add up to zero")
+	}
+	return ans.Div(MakeValue(sum))
+}
+
+// Returns the index of the largest value.
+func argmax(values []*Value) int {
+	ans := 0
+	for i, value := range values {
+		if value.data > values[ans].data {
+			ans = i
+		}
+	}
+	return ans
 }
@@ -1,7 +1,10 @@
 package nn
 
 import (
+	"math"
 	"math/rand"
+	"os"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,14 +12,13 @@ import (
 
 const (
 	delta = 0.001
+	// Seed of the random number generators of tests.
+	seed = 123456
 )
 
-func init() {
-	rand.Seed(123456)
-}
-
 func TestNeuron(t *testing.T) {
-	model := MakeNeuron(2)
+	rng := rand.New(rand.NewSource(seed))
+	model := MakeNeuron(2, rng)
 	input := []*Value{MakeValue(2), MakeValue(1)}
 	output := model.Fit(input)
 
@@ -26,33 +28,401 @@ func TestNeuron(t *testing.T) {
 }
 
 func TestLayer(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
 	layerParam := MakeLayerParam(3, Tanh)
-	model := MakeLayer(2, layerParam)
+	model := MakeLayer(2, layerParam, rng)
 
 	input := []*Value{MakeValue(2), MakeValue(1)}
 	output := model.Fit(input)
 
 	assert.Equal(t, 3, len(output), "expected %d, got %d", 3, len(output))
-	assert.InDelta(t, 0.2, output[0].GetData(), delta, "expected %f, got %f", 0.2, output[0].GetData())
-	assert.InDelta(t, -0.729, output[1].GetData(), delta, "expected %f, got %f", -0.729, output[1].GetData())
-	assert.InDelta(t, 0.099, output[2].GetData(), delta, "expected %f, got %f", 0.099, output[2].GetData())
+	assert.InDelta(t, 0.999, output[0].GetData(), delta, "expected %f, got %f", 0.999, output[0].GetData())
+	assert.InDelta(t, 0.2, output[1].GetData(), delta, "expected %f, got %f", 0.2, output[1].GetData())
+	assert.InDelta(t, -0.729, output[2].GetData(), delta, "expected %f, got %f", -0.729, output[2].GetData())
 
 	output[0].BackPropagate()
 }
 
 func TestNeuralNetwork(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
 	layerParams := []LayerParam{
 		MakeLayerParam(3, Relu),
 		MakeLayerParam(3, Relu),
 		MakeLayerParam(1, Sigmoid),
 	}
-	model := MakeNeuralNetwork(2, layerParams)
+	model := MakeNeuralNetwork(2, layerParams, rng)
 
 	input := []*Value{MakeValue(3.1), MakeValue(1.2)}
 	output := model.Fit(input)
 
 	assert.Equal(t, 1, len(output), "expected %d, got %d", 1, len(output))
-	assert.InDelta(t, 0.567, output[0].GetData(), delta, "expected %f, got %f", 0.567, output[0].GetData())
+	assert.InDelta(t, 0.293, output[0].GetData(), delta, "expected %f, got %f", 0.293, output[0].GetData())
 
 	output[0].BackPropagate()
 }
+
+// Run with -race to check forward and backward passes sharing the parameters
+// of a network are safe.
+func TestConcurrentBackward(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layerParams := []LayerParam{
+		MakeLayerParam(4, Tanh),
+		MakeLayerParam(1, Sigmoid),
+	}
+	model := MakeNeuralNetwork(2, layerParams, rng)
+	inputs := [][]*Value{}
+	for i := 0; i < 16; i++ {
+		inputs = append(inputs, []*Value{MakeValue(rng.Float64()), MakeValue(rng.Float64())})
+	}
+
+	// Gradients of the sum of outputs computed sequentially.
+	model.ResetGrad()
+	for _, input := range inputs {
+		model.Fit(input)[0].BackPropagate()
+	}
+	expected := []float64{}
+	for _, param := range model.Parameters() {
+		expected = append(expected, param.GetGrad())
+	}
+
+	model.ResetGrad()
+	var wg sync.WaitGroup
+	for _, input := range inputs {
+		wg.Add(1)
+		go func(input []*Value) {
+			defer wg.Done()
+			model.AddGrad(model.Fit(input)[0].Backward())
+		}(input)
+	}
+	wg.Wait()
+
+	for i, param := range model.Parameters() {
+		assert.InDelta(t, expected[i], param.GetGrad(), delta, "expected %f, got %f", expected[i], param.GetGrad())
+	}
+
+	// Gradients of shared parameters are added once.
+	layer := MakeLayer(2, MakeLayerParam(2, Tanh), rng)
+	shared := MakeModel(layer, layer)
+	shared.ResetGrad()
+	shared.Fit(inputs[0])[0].BackPropagate()
+	expected = []float64{}
+	for _, param := range shared.Parameters() {
+		expected = append(expected, param.GetGrad())
+	}
+	shared.ResetGrad()
+	shared.AddGrad(shared.Fit(inputs[0])[0].Backward())
+	for i, param := range shared.Parameters() {
+		assert.InDelta(t, expected[i], param.GetGrad(), delta, "expected %f, got %f", expected[i], param.GetGrad())
+	}
+}
+
+func TestHessianFree(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	lines := ReadCSV("../data/make_moon.csv")[1:101]
+	inputs := make([][]*Value, len(lines))
+	labels := make([][]*Value, len(lines))
+	for i, line := range lines {
+		input, label := getRecord(line)
+		inputs[i], labels[i] = input, []*Value{label}
+	}
+
+	layerParams := []LayerParam{
+		MakeLayerParam(8, Tanh),
+		MakeLayerParam(1, Sigmoid),
+	}
+	gdModel := MakeNeuralNetwork(2, layerParams, rng)
+	hfModel := MakeNeuralNetwork(2, layerParams, rng)
+	// Both models start from the same parameters.
+	hfParams := hfModel.Parameters()
+	for i, param := range gdModel.Parameters() {
+		hfParams[i].SetData(param.GetData())
+	}
+
+	trainingParam := TrainingParam{
+		Epochs:                  20,
+		ClassificationThreshold: 0.5,
+		LearningRate:            0.9,
+	}
+	gdLosses, _, _ := gdModel.Train(inputs, labels, trainingParam)
+
+	trainingParam.HessianFree = MakeHessianFree()
+	hfLosses, hfScores, learningRates := hfModel.Train(inputs, labels, trainingParam)
+	// The learning rate isn't used.
+	assert.True(t, math.IsNaN(learningRates[0]), "expected NaN, got %f", learningRates[0])
+	// Options of gradient steps aren't supported.
+	for name, param := range map[string]TrainingParam{
+		"AccumulationSteps": {Epochs: 1, HessianFree: MakeHessianFree(), AccumulationSteps: 2},
+		"ClipValue":         {Epochs: 1, HessianFree: MakeHessianFree(), ClipValue: 1.0},
+		"ClipNorm":          {Epochs: 1, HessianFree: MakeHessianFree(), ClipNorm: 1.0},
+	} {
+		assert.Panics(t, func() { hfModel.Train(inputs, labels, param) }, name)
+	}
+
+	assert.Equal(t, gdLosses[0], hfLosses[0], "expected %f, got %f", gdLosses[0], hfLosses[0])
+	gdLoss, hfLoss := gdLosses[len(gdLosses)-1], hfLosses[len(hfLosses)-1]
+	assert.Less(t, hfLoss, gdLoss, "expected Hessian-free loss %f to be less than gradient descent loss %f", hfLoss, gdLoss)
+	for i := 1; i < len(hfLosses); i++ {
+		assert.LessOrEqual(t, hfLosses[i], hfLosses[i-1], "loss increased at epoch %d", i)
+	}
+
+	accuracy := Accuracy(hfScores, labels, trainingParam)
+	assert.Greater(t, accuracy, 0.8, "expected accuracy > %f, got %f", 0.8, accuracy)
+}
+
+func TestLearnableActivation(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layerParams := []LayerParam{
+		MakeLearnableLayerParam(3, PRelu(0.25)),
+		MakeLearnableLayerParam(1, Swish(1.0)),
+	}
+	model := MakeNeuralNetwork(2, layerParams, rng)
+	slopes := model.modules[0].(*Layer).learnable.Parameters()
+	beta := model.modules[1].(*Layer).learnable.Parameters()[0]
+	// 3*(2+1) + 3 slopes + 1*(3+1) + beta
+	params := model.Parameters()
+	assert.Equal(t, 17, len(params), "expected %d, got %d", 17, len(params))
+
+	inputs := [][]*Value{{MakeValue(-1.0), MakeValue(-2.0)}, {MakeValue(1.0), MakeValue(0.5)}}
+	labels := [][]*Value{{MakeValue(0.0)}, {MakeValue(1.0)}}
+	scores := model.Forward(inputs)
+	loss := model.Loss(labels, scores, TrainingParam{})
+	model.ResetGrad()
+	loss.BackPropagate()
+
+	// NextData moves the activation parameters too.
+	before := beta.GetData()
+	model.NextData(0.1)
+	expected := before - 0.1*beta.GetGrad()
+	assert.InDelta(t, expected, beta.GetData(), 1e-12, "expected %f, got %f", expected, beta.GetData())
+
+	model.ResetGrad()
+	for _, slope := range slopes {
+		assert.Equalf(t, 0.0, slope.GetGrad(), "expected %f, got %f", 0.0, slope.GetGrad())
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layerParams := []LayerParam{
+		MakeLearnableLayerParam(3, PRelu(0.25)),
+		MakeLayerParam(1, Sigmoid),
+	}
+	model := MakeNeuralNetwork(2, layerParams, rng)
+	model.modules[0].(*Layer).learnable.Parameters()[1].SetData(0.5)
+	filename := t.TempDir() + "/model.csv"
+	assert.NoError(t, model.Save(filename))
+
+	loaded := MakeNeuralNetwork(2, layerParams, rng)
+	assert.NoError(t, loaded.Load(filename))
+	expected, actual := model.Parameters(), loaded.Parameters()
+	for i := range expected {
+		assert.Equalf(t, expected[i].GetData(), actual[i].GetData(), "expected %f, got %f", expected[i].GetData(), actual[i].GetData())
+	}
+
+	other := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(3, Relu), MakeLayerParam(1, Sigmoid)}, rng)
+	assert.Error(t, other.Load(filename))
+}
+
+func TestSoftmaxLayer(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layerParams := []LayerParam{
+		MakeLayerParam(4, Tanh),
+		MakeVectorLayerParam(3, Softmax),
+	}
+	model := MakeNeuralNetwork(2, layerParams, rng)
+	inputs := [][]*Value{{MakeValue(0.5), MakeValue(-1.0)}, {MakeValue(2.0), MakeValue(0.1)}}
+	labels := [][]*Value{
+		{MakeValue(0.0), MakeValue(1.0), MakeValue(0.0)},
+		{MakeValue(1.0), MakeValue(0.0), MakeValue(0.0)},
+	}
+
+	scores := model.Forward(inputs)
+	outputs := make([][]*Value, len(scores))
+	for i, score := range scores {
+		sum := 0.0
+		for _, p := range score {
+			sum += p.GetData()
+		}
+		assert.InDelta(t, 1.0, sum, 1e-12, "expected %f, got %f", 1.0, sum)
+		outputs[i] = append([]*Value{}, score...)
+	}
+
+	loss := model.Loss(labels, scores, TrainingParam{})
+	// Loss does not modify the scores.
+	assert.Equal(t, outputs, scores)
+	expected := -(math.Log(scores[0][1].GetData()) + math.Log(scores[1][0].GetData())) / 2
+	assert.InDelta(t, expected, loss.GetData(), 1e-12, "expected %f, got %f", expected, loss.GetData())
+
+	predicted := [][]*Value{{MakeValue(0.2), MakeValue(0.7), MakeValue(0.1)}, {MakeValue(0.2), MakeValue(0.7), MakeValue(0.1)}}
+	accuracy := Accuracy(predicted, labels, TrainingParam{})
+	assert.Equal(t, 0.5, accuracy, "expected %f, got %f", 0.5, accuracy)
+
+	// The default loss rejects outputs of an element-wise activation, which
+	// are not normalized.
+	exp := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(3, Exp)}, rng)
+	assert.Panics(t, func() { exp.Loss(labels, exp.Forward(inputs), TrainingParam{}) })
+	assert.NotPanics(t, func() { exp.Loss(labels, exp.Forward(inputs), TrainingParam{LossFunc: MeanSquaredError}) })
+
+	// Multi-label outputs in [0, 1] are trained by the mean binary
+	// cross-entropy of the outputs.
+	multiLabel := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(3, Sigmoid)}, rng)
+	multiLabels := [][]*Value{{MakeValue(1.0), MakeValue(1.0), MakeValue(0.0)}, {MakeValue(0.0), MakeValue(1.0), MakeValue(1.0)}}
+	scores = multiLabel.Forward(inputs)
+	expected = multiLabel.Loss(multiLabels, scores, TrainingParam{LossFunc: BinaryCrossEntropy}).GetData()
+	loss = multiLabel.Loss(multiLabels, scores, TrainingParam{})
+	assert.InDelta(t, expected, loss.GetData(), 1e-12, "expected %f, got %f", expected, loss.GetData())
+	losses, _, _ := multiLabel.Train(inputs, multiLabels, TrainingParam{Epochs: 20, LearningRate: 0.5})
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+}
+
+func TestFreeze(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layerParams := []LayerParam{
+		MakeLearnableLayerParam(3, PRelu(0.25)),
+		MakeLayerParam(1, Sigmoid),
+	}
+	model := MakeNeuralNetwork(2, layerParams, rng)
+	hidden, output := model.Modules()[0].(*Layer), model.Modules()[1].(*Layer)
+	hidden.SetTrainable(false)
+	assert.False(t, hidden.Trainable())
+	assert.True(t, output.Trainable())
+
+	frozen := []float64{}
+	for _, param := range hidden.Parameters() {
+		frozen = append(frozen, param.GetData())
+	}
+	trained := []float64{}
+	for _, param := range output.Parameters() {
+		trained = append(trained, param.GetData())
+	}
+
+	inputs := [][]*Value{{MakeValue(-1.0), MakeValue(-2.0)}, {MakeValue(1.0), MakeValue(0.5)}}
+	labels := [][]*Value{{MakeValue(0.0)}, {MakeValue(1.0)}}
+	// Regularization only sees the parameters of the output layer.
+	scores := model.Forward(inputs)
+	norm2 := 0.0
+	for _, x := range trained {
+		norm2 += x * x
+	}
+	expected := model.Loss(labels, scores, TrainingParam{}).GetData() + 0.1*norm2
+	loss := model.Loss(labels, scores, TrainingParam{Regularization: 0.1}).GetData()
+	assert.InDelta(t, expected, loss, 1e-12, "expected %f, got %f", expected, loss)
+
+	losses, _, _ := model.Train(inputs, labels, TrainingParam{Epochs: 10, LearningRate: 0.5, Regularization: 0.01})
+	assert.Less(t, losses[len(losses)-1], losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+	for i, param := range hidden.Parameters() {
+		assert.Equal(t, frozen[i], param.GetData(), "expected %f, got %f", frozen[i], param.GetData())
+		assert.Equal(t, 0.0, param.GetGrad(), "expected %f, got %f", 0.0, param.GetGrad())
+	}
+	for i, param := range output.Parameters() {
+		assert.NotEqual(t, trained[i], param.GetData(), "expected parameter %d to be trained", i)
+	}
+
+	// Hessian-free steps also keep frozen parameters.
+	model.Train(inputs, labels, TrainingParam{Epochs: 2, HessianFree: MakeHessianFree()})
+	for i, param := range hidden.Parameters() {
+		assert.Equal(t, frozen[i], param.GetData(), "expected %f, got %f", frozen[i], param.GetData())
+	}
+
+	hidden.SetTrainable(true)
+	model.Train(inputs, labels, TrainingParam{Epochs: 1, LearningRate: 0.5})
+	assert.NotEqual(t, frozen[0], hidden.Parameters()[0].GetData(), "expected unfrozen layer to be trained")
+}
+
+func TestClone(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	model := MakeModel(
+		MakeLayer(2, MakeLayerParam(3, Tanh), rng),
+		MakeBatchNorm(3, 0.5),
+		MakeLayer(3, MakeLayerParam(1, Sigmoid), rng),
+	)
+	clone := model.Clone()
+	inputs := [][]*Value{{MakeValue(-1.0), MakeValue(-2.0)}, {MakeValue(1.0), MakeValue(0.5)}}
+	labels := [][]*Value{{MakeValue(0.0)}, {MakeValue(1.0)}}
+	assert.Equal(t, model.GetWeights(), clone.GetWeights())
+
+	// Training the clone does not change the model.
+	weights := model.GetWeights()
+	clone.Train(inputs, labels, TrainingParam{Epochs: 5, LearningRate: 0.5})
+	assert.Equal(t, weights, model.GetWeights())
+	assert.NotEqual(t, weights, clone.GetWeights())
+	assert.Equal(t, 0.0, model.Modules()[1].(*BatchNorm).mean[0].GetData(), "expected running mean of the model to be unchanged")
+}
+
+func TestWeights(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layerParams := []LayerParam{
+		MakeLearnableLayerParam(3, PRelu(0.25)),
+		MakeLayerParam(1, Sigmoid),
+	}
+	model := MakeNeuralNetwork(2, layerParams, rng)
+	weights := model.GetWeights()
+	params := model.Parameters()
+	assert.Equal(t, len(params), len(weights), "expected %d, got %d", len(params), len(weights))
+	for i, param := range params {
+		assert.Equal(t, param.GetData(), weights[i], "expected %f, got %f", param.GetData(), weights[i])
+	}
+
+	other := MakeNeuralNetwork(2, layerParams, rng)
+	assert.NotEqual(t, weights, other.GetWeights())
+	assert.NoError(t, other.SetWeights(weights))
+	assert.Equal(t, weights, other.GetWeights())
+	assert.Error(t, other.SetWeights(weights[1:]))
+	assert.Equal(t, weights, other.GetWeights())
+
+	// Shared parameters are included once.
+	layer := MakeLayer(2, MakeLayerParam(2, Tanh), rng)
+	shared := MakeModel(layer, layer)
+	weights = shared.GetWeights()
+	assert.Equal(t, len(layer.Parameters()), len(weights), "expected %d, got %d", len(layer.Parameters()), len(weights))
+	otherLayer := MakeLayer(2, MakeLayerParam(2, Tanh), rng)
+	other = MakeModel(otherLayer, otherLayer)
+	assert.NoError(t, other.SetWeights(weights))
+	assert.Equal(t, weights, other.GetWeights())
+}
+
+func TestAverage(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	makeModel := func() *NeuralNetwork {
+		return MakeModel(MakeLayer(2, MakeLayerParam(2, Tanh), rng), MakeBatchNorm(2, 0.5))
+	}
+	models := []*NeuralNetwork{makeModel(), makeModel(), makeModel()}
+	models[0].Modules()[1].(*BatchNorm).mean[0].SetData(3.0)
+
+	average, err := Average(models...)
+	assert.NoError(t, err)
+	for i, x := range average.GetWeights() {
+		expected := (models[0].GetWeights()[i] + models[1].GetWeights()[i] + models[2].GetWeights()[i]) / 3
+		assert.InDelta(t, expected, x, 1e-12, "expected %f, got %f", expected, x)
+	}
+	mean := average.Modules()[1].(*BatchNorm).mean[0].GetData()
+	assert.InDelta(t, 1.0, mean, 1e-12, "expected %f, got %f", 1.0, mean)
+	assert.NotSame(t, models[0].Parameters()[0], average.Parameters()[0])
+
+	_, err = Average()
+	assert.Error(t, err)
+	_, err = Average(models[0], MakeModel(MakeLayer(2, MakeLayerParam(3, Tanh), rng), MakeBatchNorm(3, 0.5)))
+	assert.Error(t, err)
+	_, err = Average(models[0], MakeModel(MakeLayer(2, MakeLayerParam(2, Tanh), rng)))
+	assert.Error(t, err)
+}
+
+func TestGetWeightedRecords(t *testing.T) {
+	filename := t.TempDir() + "/data.csv"
+	assert.NoError(t, os.WriteFile(filename, []byte("1.0,0.5,2.0\n3.0,1.5,0.0\n"), 0644))
+	inputs, labels, weights := GetWeightedRecords(ReadCSV(filename), 2, 1)
+	// Records are shuffled.
+	if inputs[0][0].GetData() != 1.0 {
+		inputs[0], inputs[1] = inputs[1], inputs[0]
+		labels[0], labels[1] = labels[1], labels[0]
+		weights[0], weights[1] = weights[1], weights[0]
+	}
+	assert.Equal(t, [][]float64{{1.0}, {3.0}}, [][]float64{{inputs[0][0].GetData()}, {inputs[1][0].GetData()}})
+	assert.Equal(t, 1, len(inputs[0]), "expected %d, got %d", 1, len(inputs[0]))
+	assert.Equal(t, []float64{2.0, 0.0}, []float64{labels[0][0].GetData(), labels[1][0].GetData()})
+	assert.Equal(t, []float64{0.5, 1.5}, weights)
+
+	lines := [][]string{{"1.0", "0.5", "2.0"}, {"3.0", "x", "0.0"}}
+	assert.PanicsWithValue(t, `line 2, column 1: invalid sample weight: strconv.ParseFloat: parsing "x": invalid syntax`, func() { GetWeightedRecords(lines, 2, 1) })
+	assert.Panics(t, func() { GetWeightedRecords(lines[:1], 1, 3) })
+}
@@ -0,0 +1,234 @@
+package nn
+
+import (
+	"math"
+	"sync"
+)
+
+// Added to variances to avoid division by zero.
+const normalizationEpsilon = 1e-5
+
+// Batch normalization module. In training mode, it normalizes each input
+// value using the mean and variance of that value over the batch, then scales
+// and shifts it by the trainable gamma and beta:
+//
+//	y_j = gamma_j * (x_j - mean_j) / sqrt(var_j + eps) + beta_j
+//
+// Running averages of the batch statistics are used instead of the batch
+// statistics in inference mode and when a single record is fed by Forward.
+// They are saved and loaded with the model. The statistics of the batches fed
+// in training mode are collected, and the running averages are updated by
+// their mean once per optimizer step, so re-evaluations of the loss, e.g. by
+// HessianFree, don't move them.
+type BatchNorm struct {
+	gamma, beta []*Value
+	// Running mean and variance of the inputs.
+	mean, variance []*Value
+	// Weight of the new batch statistics in the running averages.
+	momentum float64
+	training bool
+	// Guards the collected statistics, since forward passes may run
+	// concurrently.
+	mu sync.Mutex
+	// Sums of the means and unbiased variances of the batches fed since the
+	// last update, and the number of batches.
+	batchMean, batchVariance []float64
+	batches                  int
+}
+
+// Makes a batch normalization module for inputs of a given size. Running
+// statistics are updated as (1-momentum)*running + momentum*batch.
+func MakeBatchNorm(size int, momentum float64) *BatchNorm {
+	b := &BatchNorm{
+		gamma:         make([]*Value, size),
+		beta:          make([]*Value, size),
+		mean:          make([]*Value, size),
+		variance:      make([]*Value, size),
+		momentum:      momentum,
+		batchMean:     make([]float64, size),
+		batchVariance: make([]float64, size),
+	}
+	for j := 0; j < size; j++ {
+		b.gamma[j], b.beta[j] = MakeValue(1.0), MakeValue(0.0)
+		b.mean[j], b.variance[j] = MakeValue(0.0), MakeValue(1.0)
+	}
+	return b
+}
+
+// Normalizes a single record using the running statistics.
+func (b *BatchNorm) Forward(input []*Value) []*Value {
+	ans := make([]*Value, len(input))
+	for j, x := range input {
+		mean := MakeValue(b.mean[j].data)
+		invStd := MakeValue(1.0 / math.Sqrt(b.variance[j].data+normalizationEpsilon))
+		ans[j] = x.Sub(mean).Mul(invStd).Mul(b.gamma[j]).Add(b.beta[j])
+	}
+	return ans
+}
+
+// Normalizes all records using the batch statistics in training mode, and
+// using the running statistics in inference mode. Gradients flow through the
+// batch mean and variance, which are collected for UpdateStatistics.
+func (b *BatchNorm) ForwardBatch(inputs [][]*Value) [][]*Value {
+	n := len(inputs)
+	ans := make([][]*Value, n)
+	if !b.training || n == 0 {
+		for i, input := range inputs {
+			ans[i] = b.Forward(input)
+		}
+		return ans
+	}
+	for i := range ans {
+		ans[i] = make([]*Value, len(b.gamma))
+	}
+	invN := MakeValue(1.0 / float64(n))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batches++
+	for j := range b.gamma {
+		mean := MakeValue(0.0)
+		for _, input := range inputs {
+			mean = mean.Add(input[j])
+		}
+		mean = mean.Mul(invN)
+		centered := make([]*Value, n)
+		variance := MakeValue(0.0)
+		for i, input := range inputs {
+			centered[i] = input[j].Sub(mean)
+			variance = variance.Add(centered[i].Mul(centered[i]))
+		}
+		variance = variance.Mul(invN)
+		invStd := variance.Add(MakeValue(normalizationEpsilon)).Pow(-0.5)
+		for i := range inputs {
+			ans[i][j] = centered[i].Mul(invStd).Mul(b.gamma[j]).Add(b.beta[j])
+		}
+
+		// The running variance is unbiased.
+		unbiased := variance.data
+		if n > 1 {
+			unbiased *= float64(n) / float64(n-1)
+		}
+		b.batchMean[j] += mean.data
+		b.batchVariance[j] += unbiased
+	}
+	return ans
+}
+
+// Updates the running statistics by the mean of the batch statistics
+// collected since the last update, and forgets them. It's called after each
+// optimizer step.
+func (b *BatchNorm) UpdateStatistics() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.batches == 0 {
+		return
+	}
+	for j := range b.mean {
+		mean, variance := b.batchMean[j]/float64(b.batches), b.batchVariance[j]/float64(b.batches)
+		b.mean[j].data = (1.0-b.momentum)*b.mean[j].data + b.momentum*mean
+		b.variance[j].data = (1.0-b.momentum)*b.variance[j].data + b.momentum*variance
+	}
+	b.discardStatistics()
+}
+
+// Forgets the collected batch statistics without updating the running
+// statistics.
+func (b *BatchNorm) DiscardStatistics() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.discardStatistics()
+}
+
+func (b *BatchNorm) discardStatistics() {
+	for j := range b.batchMean {
+		b.batchMean[j], b.batchVariance[j] = 0.0, 0.0
+	}
+	b.batches = 0
+}
+
+// Returns gamma followed by beta.
+func (b *BatchNorm) Parameters() []*Value {
+	return append(append([]*Value{}, b.gamma...), b.beta...)
+}
+
+// Returns the running mean followed by the running variance.
+func (b *BatchNorm) State() []*Value {
+	return append(append([]*Value{}, b.mean...), b.variance...)
+}
+
+// Switches between using batch statistics (training) and running statistics
+// (inference).
+func (b *BatchNorm) SetTraining(training bool) {
+	b.training = training
+}
+
+// Layer normalization module. It normalizes the input values of each record
+// using their mean and variance, then scales and shifts them by the trainable
+// gamma and beta:
+//
+//	y_j = gamma_j * (x_j - mean) / sqrt(var + eps) + beta_j
+type LayerNorm struct {
+	gamma, beta []*Value
+}
+
+// Makes a layer normalization module for inputs of a given size.
+func MakeLayerNorm(size int) *LayerNorm {
+	l := &LayerNorm{
+		gamma: make([]*Value, size),
+		beta:  make([]*Value, size),
+	}
+	for j := 0; j < size; j++ {
+		l.gamma[j], l.beta[j] = MakeValue(1.0), MakeValue(0.0)
+	}
+	return l
+}
+
+// Normalizes the input values of a record.
+func (l *LayerNorm) Forward(input []*Value) []*Value {
+	invN := MakeValue(1.0 / float64(len(input)))
+	mean := MakeValue(0.0)
+	for _, x := range input {
+		mean = mean.Add(x)
+	}
+	mean = mean.Mul(invN)
+	centered := make([]*Value, len(input))
+	variance := MakeValue(0.0)
+	for j, x := range input {
+		centered[j] = x.Sub(mean)
+		variance = variance.Add(centered[j].Mul(centered[j]))
+	}
+	invStd := variance.Mul(invN).Add(MakeValue(normalizationEpsilon)).Pow(-0.5)
+	ans := make([]*Value, len(input))
+	for j := range centered {
+		ans[j] = centered[j].Mul(invStd).Mul(l.gamma[j]).Add(l.beta[j])
+	}
+	return ans
+}
+
+// Returns gamma followed by beta.
+func (l *LayerNorm) Parameters() []*Value {
+	return append(append([]*Value{}, l.gamma...), l.beta...)
+}
+
+// Returns a copy of the module with new parameters and running statistics of
+// the same values.
+func (b *BatchNorm) Clone() Module {
+	return &BatchNorm{
+		gamma:         copyValues(b.gamma),
+		beta:          copyValues(b.beta),
+		mean:          copyValues(b.mean),
+		variance:      copyValues(b.variance),
+		momentum:      b.momentum,
+		training:      b.training,
+		batchMean:     make([]float64, len(b.gamma)),
+		batchVariance: make([]float64, len(b.gamma)),
+	}
+}
+
+// Returns a copy of the module with new parameters of the same values.
+func (l *LayerNorm) Clone() Module {
+	return &LayerNorm{
+		gamma: copyValues(l.gamma),
+		beta:  copyValues(l.beta),
+	}
+}
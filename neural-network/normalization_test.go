@@ -0,0 +1,131 @@
+package nn
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchNorm(t *testing.T) {
+	inputs := makeRecords([][]float64{{1.0, -2.0}, {3.0, 0.5}, {-1.0, 4.0}, {2.0, 1.5}})
+	bn := MakeBatchNorm(2, 1.0)
+	bn.gamma[1].SetData(2.0)
+	bn.beta[1].SetData(-1.0)
+	bn.SetTraining(true)
+
+	outputs := bn.ForwardBatch(inputs)
+	for j, expected := range []struct{ mean, std float64 }{{0.0, 1.0}, {-1.0, 2.0}} {
+		mean, variance := 0.0, 0.0
+		for _, output := range outputs {
+			mean += output[j].GetData() / 4
+		}
+		for _, output := range outputs {
+			variance += math.Pow(output[j].GetData()-mean, 2) / 4
+		}
+		assert.InDelta(t, expected.mean, mean, 1e-6, "expected %f, got %f", expected.mean, mean)
+		assert.InDelta(t, expected.std, math.Sqrt(variance), 1e-4, "expected %f, got %f", expected.std, math.Sqrt(variance))
+	}
+
+	// Running statistics are updated by the collected batch statistics. With
+	// momentum 1, they are the mean of the batch statistics.
+	assert.Equal(t, 0.0, bn.mean[0].GetData())
+	bn.ForwardBatch(inputs)
+	bn.UpdateStatistics()
+	assert.InDelta(t, 1.25, bn.mean[0].GetData(), 1e-12, "expected %f, got %f", 1.25, bn.mean[0].GetData())
+	expected := (0.0625 + 3.0625 + 5.0625 + 0.5625) / 3
+	assert.InDelta(t, expected, bn.variance[0].GetData(), 1e-12, "expected %f, got %f", expected, bn.variance[0].GetData())
+
+	// Inference uses the running statistics.
+	bn.SetTraining(false)
+	output := bn.ForwardBatch(inputs[:1])[0][0].GetData()
+	expected = (1.0 - 1.25) / math.Sqrt(expected+normalizationEpsilon)
+	assert.InDelta(t, expected, output, 1e-12, "expected %f, got %f", expected, output)
+}
+
+func TestBatchNormGradient(t *testing.T) {
+	inputs := makeRecords([][]float64{{1.0, -2.0}, {3.0, 0.5}, {-1.0, 4.0}})
+	bn := MakeBatchNorm(2, 0.1)
+	bn.gamma[0].SetData(0.5)
+	bn.beta[1].SetData(0.3)
+	bn.SetTraining(true)
+
+	values := bn.Parameters()
+	for _, input := range inputs {
+		values = append(values, input...)
+	}
+	checkGradient(t, values, func() *Value {
+		return weightedSum(bn.ForwardBatch(inputs)...)
+	})
+}
+
+func TestBatchNormSaveLoad(t *testing.T) {
+	bn := MakeBatchNorm(2, 0.5)
+	bn.SetTraining(true)
+	bn.ForwardBatch(makeRecords([][]float64{{1.0, -2.0}, {3.0, 0.5}}))
+	bn.UpdateStatistics()
+	filename := t.TempDir() + "/model.csv"
+	assert.NoError(t, MakeModel(MakeSequential(bn)).Save(filename))
+
+	loaded := MakeBatchNorm(2, 0.5)
+	assert.NoError(t, MakeModel(MakeSequential(loaded)).Load(filename))
+	expected, actual := bn.State(), loaded.State()
+	for i := range expected {
+		assert.Equalf(t, expected[i].GetData(), actual[i].GetData(), "expected %f, got %f", expected[i].GetData(), actual[i].GetData())
+	}
+}
+
+func TestLayerNorm(t *testing.T) {
+	input := []*Value{MakeValue(1.0), MakeValue(-2.0), MakeValue(4.0), MakeValue(0.5)}
+	ln := MakeLayerNorm(4)
+	output := ln.Forward(input)
+	mean, variance := 0.0, 0.0
+	for _, y := range output {
+		mean += y.GetData() / 4
+	}
+	for _, y := range output {
+		variance += math.Pow(y.GetData()-mean, 2) / 4
+	}
+	assert.InDelta(t, 0.0, mean, 1e-9, "expected %f, got %f", 0.0, mean)
+	assert.InDelta(t, 1.0, variance, 1e-4, "expected %f, got %f", 1.0, variance)
+
+	ln.gamma[2].SetData(1.5)
+	ln.beta[0].SetData(-0.5)
+	checkGradient(t, append(ln.Parameters(), input...), func() *Value {
+		return weightedSum(ln.Forward(input))
+	})
+}
+
+func TestBatchNormTrain(t *testing.T) {
+	bn := MakeBatchNorm(1, 1.0)
+	model := MakeModel(bn, &affine{MakeValue(1.0), MakeValue(0.0)}, activation(elementwise(Sigmoid)))
+	inputs := makeRecords([][]float64{{1.0}, {2.0}, {6.0}})
+	labels := makeRecords([][]float64{{0.0}, {0.0}, {1.0}})
+	model.Train(inputs, labels, TrainingParam{Epochs: 1, LearningRate: 0.1})
+
+	// Running statistics are tracked during training.
+	assert.InDelta(t, 3.0, bn.mean[0].GetData(), 1e-12, "expected %f, got %f", 3.0, bn.mean[0].GetData())
+	assert.InDelta(t, 7.0, bn.variance[0].GetData(), 1e-12, "expected %f, got %f", 7.0, bn.variance[0].GetData())
+	assert.NotEqual(t, 1.0, bn.gamma[0].GetData(), "expected gamma to be trained")
+}
+
+func TestBatchNormStatistics(t *testing.T) {
+	inputs := makeRecords([][]float64{{1.0}, {2.0}, {6.0}})
+	labels := makeRecords([][]float64{{0.0}, {0.0}, {1.0}})
+
+	// Discarded statistics don't change the running statistics.
+	bn := MakeBatchNorm(1, 0.5)
+	bn.SetTraining(true)
+	bn.ForwardBatch(inputs)
+	bn.DiscardStatistics()
+	bn.UpdateStatistics()
+	assert.Equal(t, []float64{0.0, 1.0}, []float64{bn.mean[0].GetData(), bn.variance[0].GetData()})
+
+	// The running statistics are updated once per step of HessianFree, which
+	// evaluates the loss many times.
+	bn = MakeBatchNorm(1, 0.5)
+	model := MakeModel(bn, &affine{MakeValue(1.0), MakeValue(0.0)}, activation(elementwise(Sigmoid)))
+	model.Train(inputs, labels, TrainingParam{Epochs: 1, HessianFree: MakeHessianFree()})
+	assert.InDelta(t, 1.5, bn.mean[0].GetData(), 1e-12, "expected %f, got %f", 1.5, bn.mean[0].GetData())
+	assert.InDelta(t, 4.0, bn.variance[0].GetData(), 1e-12, "expected %f, got %f", 4.0, bn.variance[0].GetData())
+}
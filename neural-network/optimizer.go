@@ -0,0 +1,186 @@
+package nn
+
+import (
+	"math"
+)
+
+// An optimizer updates parameters given their gradients. Optimizers keep
+// per-parameter state, e.g. moment buffers, so an optimizer should be reused
+// between steps and not shared between networks.
+type Optimizer interface {
+	// Updates the data of params by one step given their gradients and the
+	// learning rate.
+	Update(params []*Value, learningRate float64)
+}
+
+// Per-parameter state of an optimizer.
+type paramState struct {
+	// Running averages or sums, e.g. the first and second moments of the
+	// gradients.
+	first, second float64
+	// Number of updates of the parameter.
+	steps int
+}
+
+type optimizerState map[*Value]*paramState
+
+// Returns the state of a parameter, creating it if needed.
+func (s *optimizerState) of(param *Value) *paramState {
+	if *s == nil {
+		*s = optimizerState{}
+	}
+	state, ok := (*s)[param]
+	if !ok {
+		state = &paramState{}
+		(*s)[param] = state
+	}
+	return state
+}
+
+// Stochastic gradient descent with momentum: v = Momentum*v + g and
+// w -= learningRate*v. With Nesterov momentum the update is
+// learningRate*(g + Momentum*v) instead. Without momentum it is plain
+// gradient descent.
+type SGD struct {
+	Momentum float64
+	Nesterov bool
+	state    optimizerState
+}
+
+// Makes a gradient descent optimizer with a given momentum.
+func MakeSGD(momentum float64) *SGD {
+	return &SGD{Momentum: momentum}
+}
+
+// Makes a gradient descent optimizer with a given Nesterov momentum.
+func MakeNesterov(momentum float64) *SGD {
+	return &SGD{Momentum: momentum, Nesterov: true}
+}
+
+func (o *SGD) Update(params []*Value, learningRate float64) {
+	for _, param := range params {
+		if o.Momentum == 0.0 {
+			param.data -= learningRate * param.grad
+			continue
+		}
+		state := o.state.of(param)
+		state.first = o.Momentum*state.first + param.grad
+		update := state.first
+		if o.Nesterov {
+			update = param.grad + o.Momentum*state.first
+		}
+		param.data -= learningRate * update
+	}
+}
+
+// Adam optimizer keeping running averages m and v of the gradients and their
+// squares with bias correction:
+//
+//	w -= learningRate*(m/(1-Beta1^t) / (sqrt(v/(1-Beta2^t)) + Epsilon) + WeightDecay*w)
+//
+// where t is the number of updates of the parameter. WeightDecay is decoupled
+// from the gradients as in AdamW, and applies to all parameters given to
+// Update, unlike TrainingParam.WeightDecay.
+type Adam struct {
+	Beta1, Beta2 float64
+	Epsilon      float64
+	WeightDecay  float64
+	state        optimizerState
+}
+
+// Makes an Adam optimizer with the defaults of the paper.
+func MakeAdam() *Adam {
+	return &Adam{Beta1: 0.9, Beta2: 0.999, Epsilon: 1e-8}
+}
+
+// Makes an Adam optimizer with a given decoupled weight decay.
+func MakeAdamW(weightDecay float64) *Adam {
+	adam := MakeAdam()
+	adam.WeightDecay = weightDecay
+	return adam
+}
+
+func (o *Adam) Update(params []*Value, learningRate float64) {
+	for _, param := range params {
+		state := o.state.of(param)
+		state.steps++
+		state.first = o.Beta1*state.first + (1-o.Beta1)*param.grad
+		state.second = o.Beta2*state.second + (1-o.Beta2)*param.grad*param.grad
+		m := state.first / (1 - math.Pow(o.Beta1, float64(state.steps)))
+		v := state.second / (1 - math.Pow(o.Beta2, float64(state.steps)))
+		param.data -= learningRate * (m/(math.Sqrt(v)+o.Epsilon) + o.WeightDecay*param.data)
+	}
+}
+
+// RMSProp optimizer scaling the gradients by a running average s of their
+// squares: s = Decay*s + (1-Decay)*g^2 and w -= learningRate*g/(sqrt(s) +
+// Epsilon).
+type RMSProp struct {
+	Decay   float64
+	Epsilon float64
+	state   optimizerState
+}
+
+// Makes an RMSProp optimizer with common defaults.
+func MakeRMSProp() *RMSProp {
+	return &RMSProp{Decay: 0.9, Epsilon: 1e-8}
+}
+
+func (o *RMSProp) Update(params []*Value, learningRate float64) {
+	for _, param := range params {
+		state := o.state.of(param)
+		state.second = o.Decay*state.second + (1-o.Decay)*param.grad*param.grad
+		param.data -= learningRate * param.grad / (math.Sqrt(state.second) + o.Epsilon)
+	}
+}
+
+// Adagrad optimizer scaling the gradients by the sum s of their squares:
+// s += g^2 and w -= learningRate*g/(sqrt(s) + Epsilon), so frequently updated
+// parameters take smaller steps.
+type Adagrad struct {
+	Epsilon float64
+	state   optimizerState
+}
+
+// Makes an Adagrad optimizer.
+func MakeAdagrad() *Adagrad {
+	return &Adagrad{Epsilon: 1e-10}
+}
+
+func (o *Adagrad) Update(params []*Value, learningRate float64) {
+	for _, param := range params {
+		state := o.state.of(param)
+		state.second += param.grad * param.grad
+		param.data -= learningRate * param.grad / (math.Sqrt(state.second) + o.Epsilon)
+	}
+}
+
+// Adadelta optimizer keeping running averages s of the squared gradients and
+// u of the squared updates:
+//
+//	s = Rho*s + (1-Rho)*g^2
+//	d = sqrt(u + Epsilon)/sqrt(s + Epsilon)*g
+//	u = Rho*u + (1-Rho)*d^2
+//
+// and w -= learningRate*d. The original method has no learning rate, which is
+// learningRate = 1.
+type Adadelta struct {
+	Rho     float64
+	Epsilon float64
+	state   optimizerState
+}
+
+// Makes an Adadelta optimizer with the defaults of the paper.
+func MakeAdadelta() *Adadelta {
+	return &Adadelta{Rho: 0.95, Epsilon: 1e-6}
+}
+
+func (o *Adadelta) Update(params []*Value, learningRate float64) {
+	for _, param := range params {
+		state := o.state.of(param)
+		state.second = o.Rho*state.second + (1-o.Rho)*param.grad*param.grad
+		delta := math.Sqrt(state.first+o.Epsilon) / math.Sqrt(state.second+o.Epsilon) * param.grad
+		state.first = o.Rho*state.first + (1-o.Rho)*delta*delta
+		param.data -= learningRate * delta
+	}
+}
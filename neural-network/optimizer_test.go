@@ -0,0 +1,97 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimizerUpdates(t *testing.T) {
+	// Two steps with gradients 1 and 2, starting from w = 1.
+	adam1 := 0.1 * 1.0 / (1.0 + 1e-8)
+	m, v := (0.9*0.1+0.1*2.0)/(1-0.81), (0.999*0.001+0.001*4.0)/(1-0.999*0.999)
+	adam2 := 0.1 * m / (math.Sqrt(v) + 1e-8)
+	rms1 := 0.1 * 1.0 / (math.Sqrt(0.1) + 1e-8)
+	rms2 := 0.1 * 2.0 / (math.Sqrt(0.9*0.1+0.1*4.0) + 1e-8)
+	delta1 := math.Sqrt(1e-6) / math.Sqrt(0.05+1e-6)
+	delta2 := math.Sqrt(0.05*delta1*delta1+1e-6) / math.Sqrt(0.95*0.05+0.05*4.0+1e-6) * 2.0
+	tests := map[string]struct {
+		optimizer Optimizer
+		expected  [2]float64
+	}{
+		"SGD":      {MakeSGD(0.0), [2]float64{1.0 - 0.1, 1.0 - 0.1 - 0.2}},
+		"Momentum": {MakeSGD(0.5), [2]float64{1.0 - 0.1, 1.0 - 0.1 - 0.25}},
+		"Nesterov": {MakeNesterov(0.5), [2]float64{1.0 - 0.15, 1.0 - 0.15 - 0.325}},
+		"Adam":     {MakeAdam(), [2]float64{1.0 - adam1, 1.0 - adam1 - adam2}},
+		"AdamW":    {MakeAdamW(0.5), [2]float64{1.0 - adam1 - 0.05, (1.0-adam1-0.05)*0.95 - adam2}},
+		"RMSProp":  {MakeRMSProp(), [2]float64{1.0 - rms1, 1.0 - rms1 - rms2}},
+		"Adagrad":  {MakeAdagrad(), [2]float64{1.0 - 0.1, 1.0 - 0.1 - 0.2/math.Sqrt(5.0)}},
+		"Adadelta": {MakeAdadelta(), [2]float64{1.0 - 0.1*delta1, 1.0 - 0.1*delta1 - 0.1*delta2}},
+	}
+	for name, test := range tests {
+		param := MakeValue(1.0)
+		for i, grad := range []float64{1.0, 2.0} {
+			param.grad = grad
+			test.optimizer.Update([]*Value{param}, 0.1)
+			assert.InDelta(t, test.expected[i], param.GetData(), 1e-9, "%s, step %d: expected %f, got %f", name, i+1, test.expected[i], param.GetData())
+		}
+	}
+}
+
+func TestOptimizers(t *testing.T) {
+	lines := ReadCSV("../data/make_moon.csv")[1:101]
+	inputs := make([][]*Value, len(lines))
+	labels := make([][]*Value, len(lines))
+	for i, line := range lines {
+		input, label := getRecord(line)
+		inputs[i], labels[i] = input, []*Value{label}
+	}
+	layerParams := []LayerParam{MakeLayerParam(8, Tanh), MakeLayerParam(1, Sigmoid)}
+	tests := map[string]struct {
+		optimizer    Optimizer
+		learningRate float64
+		epochs       int
+	}{
+		"SGD":      {MakeSGD(0.0), 0.5, 100},
+		"Momentum": {MakeSGD(0.9), 0.1, 100},
+		"Nesterov": {MakeNesterov(0.9), 0.1, 100},
+		"Adam":     {MakeAdam(), 0.05, 100},
+		"AdamW":    {MakeAdamW(0.01), 0.05, 100},
+		"RMSProp":  {MakeRMSProp(), 0.02, 100},
+		"Adagrad":  {MakeAdagrad(), 0.2, 100},
+		// Adadelta starts with small steps of about sqrt(Epsilon).
+		"Adadelta": {MakeAdadelta(), 1.0, 300},
+	}
+	for name, test := range tests {
+		model := MakeNeuralNetwork(2, layerParams, rand.New(rand.NewSource(seed)))
+		trainingParam := TrainingParam{
+			Epochs:                  test.epochs,
+			LearningRate:            test.learningRate,
+			ClassificationThreshold: 0.5,
+			Optimizer:               test.optimizer,
+		}
+		losses, scores, _ := model.Train(inputs, labels, trainingParam)
+		last := losses[len(losses)-1]
+		accuracy := Accuracy(scores, labels, trainingParam)
+		assert.Less(t, last, 0.5*losses[0], "%s: expected loss < %f, got %f", name, 0.5*losses[0], last)
+		assert.Greater(t, accuracy, 0.85, "%s: expected accuracy > %f, got %f", name, 0.85, accuracy)
+	}
+}
+
+func TestStep(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	model := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(1, Sigmoid)}, rng)
+	clone := model.Clone()
+	input := []*Value{MakeValue(1.0), MakeValue(-1.0)}
+
+	// Step with gradient descent is NextData.
+	for _, m := range []*NeuralNetwork{model, clone} {
+		m.ResetGrad()
+		m.Fit(input)[0].BackPropagate()
+	}
+	model.NextData(0.1)
+	clone.Step(MakeSGD(0.0), 0.1)
+	assert.Equal(t, model.GetWeights(), clone.GetWeights())
+}
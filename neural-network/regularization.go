@@ -0,0 +1,197 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Modules made of neurons, e.g. Layer, implement NeuronModule so the
+// intercepts of the neurons can be excluded from regularization and max-norm
+// constraints can be applied on the weights of each neuron.
+type NeuronModule interface {
+	// Returns the neurons of the module. The same neurons must be returned
+	// in the same order on every call.
+	Neurons() []*Neuron
+}
+
+// Returns the neurons of all modules implementing NeuronModule.
+func neurons(modules []Module) []*Neuron {
+	ans := []*Neuron{}
+	for _, module := range modules {
+		if m, ok := module.(NeuronModule); ok {
+			ans = append(ans, m.Neurons()...)
+		}
+	}
+	return ans
+}
+
+// Returns the neurons of all modules.
+func (s *Sequential) Neurons() []*Neuron {
+	return neurons(s.modules)
+}
+
+// Returns a node computing the sum of f(x) over values with derivative g, so
+// penalties over all parameters add a single node to the graph.
+func penalty(op string, values []*Value, f, g func(float64) float64) *Value {
+	data := 0.0
+	for _, value := range values {
+		data += f(value.data)
+	}
+	ans := &Value{
+		data:     data,
+		op:       op,
+		children: values,
+	}
+	ans.backward = func(grad float64, accumulate accumulator) {
+		for _, value := range values {
+			accumulate(value, g(value.data)*grad)
+		}
+	}
+	return ans
+}
+
+// Regularized parameters with the same scale of the regularization
+// strengths.
+type regularizedGroup struct {
+	scale  float64
+	params []*Value
+}
+
+// Returns the trainable parameters of the network which are regularized,
+// grouped by the scales of their strengths. Parameters of modules in
+// RegularizationScales are scaled, and intercepts of neurons are excluded if
+// ExcludeIntercepts is set. Only the used parameters of modules implementing
+// SparseModule are regularized, since the others aren't updated by the step.
+func (n *NeuralNetwork) regularized(trainingParam TrainingParam) []regularizedGroup {
+	scales := map[*Value]float64{}
+	// Modules with more parameters first, so the scales of modules nested
+	// in them take precedence.
+	type scaledModule struct {
+		params []*Value
+		scale  float64
+	}
+	modules := make([]scaledModule, 0, len(trainingParam.RegularizationScales))
+	for module, scale := range trainingParam.RegularizationScales {
+		modules = append(modules, scaledModule{module.Parameters(), scale})
+	}
+	sort.SliceStable(modules, func(i, j int) bool {
+		return len(modules[i].params) > len(modules[j].params)
+	})
+	// Sizes of the modules which set the scales, to detect modules of the same
+	// size sharing parameters with different scales, e.g. a Sequential of a
+	// single Layer, since neither takes precedence.
+	sizes := map[*Value]int{}
+	for _, module := range modules {
+		for _, param := range module.params {
+			if scale, ok := scales[param]; ok && sizes[param] == len(module.params) && scale != module.scale {
+				panic(fmt.Sprintf("conflicting regularization scales %g and %g for modules with the same parameters", scale, module.scale))
+			}
+			scales[param] = module.scale
+			sizes[param] = len(module.params)
+		}
+	}
+	if trainingParam.ExcludeIntercepts {
+		for _, neuron := range neurons(n.modules) {
+			scales[neuron.intercept] = 0.0
+		}
+	}
+
+	groups := []regularizedGroup{}
+	indices := map[float64]int{}
+	for _, param := range unique(trainable(usedParameters(n.modules))) {
+		scale, ok := scales[param]
+		if !ok {
+			scale = 1.0
+		}
+		if scale == 0.0 {
+			continue
+		}
+		if _, ok := indices[scale]; !ok {
+			indices[scale] = len(groups)
+			groups = append(groups, regularizedGroup{scale: scale})
+		}
+		group := &groups[indices[scale]]
+		group.params = append(group.params, param)
+	}
+	return groups
+}
+
+// Returns the L1 and L2 penalties of the regularized parameters, or nil if
+// there are none.
+func (n *NeuralNetwork) regularization(trainingParam TrainingParam) *Value {
+	l1, l2 := trainingParam.L1Regularization, trainingParam.Regularization
+	if l1 <= 0.0 && l2 <= 0.0 {
+		return nil
+	}
+	ans := MakeValue(0.0)
+	for _, group := range n.regularized(trainingParam) {
+		if l1 > 0.0 {
+			norm1 := penalty("L1", group.params, math.Abs, sign)
+			ans = ans.Add(norm1.Mul(MakeValue(group.scale * l1)))
+		}
+		if l2 > 0.0 {
+			norm2 := penalty("L2", group.params, func(x float64) float64 { return x * x }, func(x float64) float64 { return 2 * x })
+			ans = ans.Add(norm2.Mul(MakeValue(group.scale * l2)))
+		}
+	}
+	return ans
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x > 0.0:
+		return 1.0
+	case x < 0.0:
+		return -1.0
+	}
+	return 0.0
+}
+
+// Clips the gradients and updates the parameters by the optimizer, or
+// gradient descent if it is nil, with a given learning rate and decoupled
+// weight decay w -= learningRate*weightDecay*w, updates the running
+// statistics of the modules, and then rescales the weights of each neuron
+// whose norm exceeds MaxNorm.
+func (n *NeuralNetwork) step(trainingParam TrainingParam, learningRate float64) {
+	weightDecay := trainingParam.WeightDecay
+	params := unique(trainable(usedParameters(n.modules)))
+	clipGradients(params, trainingParam)
+	if weightDecay > 0.0 {
+		for _, group := range n.regularized(trainingParam) {
+			for _, param := range group.params {
+				param.data -= learningRate * group.scale * weightDecay * param.data
+			}
+		}
+	}
+	optimizer := trainingParam.Optimizer
+	if optimizer == nil {
+		optimizer = &SGD{}
+	}
+	optimizer.Update(params, learningRate)
+	clearUsed(n.modules)
+	updateStatistics(n.modules)
+	n.constrain(trainingParam)
+}
+
+// Rescales the weights of each neuron whose norm exceeds MaxNorm to MaxNorm.
+func (n *NeuralNetwork) constrain(trainingParam TrainingParam) {
+	if trainingParam.MaxNorm <= 0.0 {
+		return
+	}
+	for _, neuron := range neurons(n.modules) {
+		norm := 0.0
+		for _, weight := range neuron.weights {
+			norm += weight.data * weight.data
+		}
+		norm = math.Sqrt(norm)
+		if norm <= trainingParam.MaxNorm {
+			continue
+		}
+		for _, weight := range neuron.weights {
+			if weight.Trainable() {
+				weight.data *= trainingParam.MaxNorm / norm
+			}
+		}
+	}
+}
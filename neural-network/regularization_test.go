@@ -0,0 +1,109 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the sum of f over the data of values.
+func sumData(values []*Value, f func(float64) float64) float64 {
+	ans := 0.0
+	for _, value := range values {
+		ans += f(value.GetData())
+	}
+	return ans
+}
+
+func TestRegularization(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	hidden := MakeLayer(2, MakeLayerParam(3, Tanh), rng)
+	output := MakeLayer(3, MakeLayerParam(1, Sigmoid), rng)
+	model := MakeModel(hidden, output)
+	inputs := makeRecords([][]float64{{1.0, 2.0}, {-1.0, 0.5}})
+	labels := makeRecords([][]float64{{1.0}, {0.0}})
+	scores := model.Forward(inputs)
+	loss := model.Loss(labels, scores, TrainingParam{}).GetData()
+	square := func(x float64) float64 { return x * x }
+
+	// Elastic net adds a constant number of nodes besides the parameters.
+	trainingParam := TrainingParam{Regularization: 0.1, L1Regularization: 0.01}
+	penalty := model.regularization(trainingParam)
+	expected := 0.1*sumData(model.Parameters(), square) + 0.01*sumData(model.Parameters(), math.Abs)
+	actual := model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, loss+expected, actual, 1e-12, "expected %f, got %f", loss+expected, actual)
+	sorted := []*Value{}
+	topoSort(penalty, map[*Value]bool{}, &sorted)
+	assert.Equal(t, len(model.Parameters())+9, len(sorted), "expected %d, got %d", len(model.Parameters())+9, len(sorted))
+	checkGradient(t, model.Parameters(), func() *Value {
+		return model.Loss(labels, model.Forward(inputs), trainingParam)
+	})
+
+	// Intercepts are excluded and the output layer has twice the strength.
+	trainingParam = TrainingParam{
+		Regularization:       0.1,
+		ExcludeIntercepts:    true,
+		RegularizationScales: map[Module]float64{output: 2.0},
+	}
+	weights := func(layer *Layer) []*Value {
+		ans := []*Value{}
+		for _, neuron := range layer.Neurons() {
+			ans = append(ans, neuron.weights...)
+		}
+		return ans
+	}
+	expected = 0.1*sumData(weights(hidden), square) + 0.2*sumData(weights(output), square)
+	actual = model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, loss+expected, actual, 1e-12, "expected %f, got %f", loss+expected, actual)
+
+	// Nested modules take precedence.
+	trainingParam.RegularizationScales = map[Module]float64{model.Modules()[0]: 0.0, MakeSequential(hidden, output): 2.0}
+	expected = 0.2 * sumData(weights(output), square)
+	actual = model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, loss+expected, actual, 1e-12, "expected %f, got %f", loss+expected, actual)
+
+	// Modules with the same parameters can't have different scales, since
+	// neither takes precedence, but can have equal ones.
+	trainingParam.RegularizationScales = map[Module]float64{output: 0.0, MakeSequential(output): 2.0}
+	assert.Panics(t, func() { model.Loss(labels, scores, trainingParam) })
+	trainingParam.RegularizationScales = map[Module]float64{output: 2.0, MakeSequential(output): 2.0}
+	expected = 0.1*sumData(weights(hidden), square) + 0.2*sumData(weights(output), square)
+	actual = model.Loss(labels, scores, trainingParam).GetData()
+	assert.InDelta(t, loss+expected, actual, 1e-12, "expected %f, got %f", loss+expected, actual)
+}
+
+func TestWeightDecay(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	layer := MakeLayer(2, MakeLayerParam(2, Tanh), rng)
+	model := MakeModel(layer)
+	layer.neurons[1].weights[0].SetTrainable(false)
+	before := model.GetWeights()
+
+	// Decay does not change the loss and with zero gradients only shrinks
+	// the trainable weights.
+	trainingParam := TrainingParam{LearningRate: 0.5, WeightDecay: 0.1, ExcludeIntercepts: true}
+	assert.Nil(t, model.regularization(trainingParam))
+	model.ResetGrad()
+	model.step(trainingParam, trainingParam.LearningRate)
+	for i, param := range model.Parameters() {
+		expected := before[i] * 0.95
+		if param == layer.neurons[0].intercept || param == layer.neurons[1].intercept || !param.Trainable() {
+			expected = before[i]
+		}
+		assert.InDelta(t, expected, param.GetData(), 1e-12, "expected %f, got %f", expected, param.GetData())
+	}
+}
+
+func TestMaxNorm(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	model := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(4, Tanh), MakeLayerParam(1, Sigmoid)}, rng)
+	inputs := makeRecords([][]float64{{1.0, 2.0}, {-1.0, 0.5}})
+	labels := makeRecords([][]float64{{1.0}, {0.0}})
+	model.Train(inputs, labels, TrainingParam{Epochs: 3, LearningRate: 0.5, MaxNorm: 0.5})
+	for _, neuron := range neurons(model.Modules()) {
+		norm := math.Sqrt(sumData(neuron.weights, func(x float64) float64 { return x * x }))
+		assert.LessOrEqual(t, norm, 0.5+1e-12, "expected norm <= %f, got %f", 0.5, norm)
+	}
+}
@@ -0,0 +1,393 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// A recurrent cell computes an output and the next state from an input and
+// the current state. Cells are unrolled over sequences by Unroll or the RNN
+// module.
+type Cell interface {
+	// Computes the output and the next state given an input and the current
+	// state.
+	Step(input, state []*Value) (output, next []*Value)
+	// Returns a new initial state.
+	InitialState() []*Value
+	// Returns the trainable parameters of the cell.
+	Parameters() []*Value
+}
+
+// Cells implement CloneableCell so RNN modules using them can be copied.
+type CloneableCell interface {
+	// Returns a deep copy of the cell which shares no values with it.
+	Clone() Cell
+}
+
+// Applies a cell on each step of a sequence starting from a given state, or
+// from the initial state of the cell if state is nil. Returns the output of
+// each step and the final state. Sequences can have any length.
+func Unroll(cell Cell, sequence [][]*Value, state []*Value) ([][]*Value, []*Value) {
+	if state == nil {
+		state = cell.InitialState()
+	}
+	outputs := make([][]*Value, len(sequence))
+	for t, input := range sequence {
+		outputs[t], state = cell.Step(input, state)
+	}
+	return outputs, state
+}
+
+// Returns a state of zeros.
+func zeros(size int) []*Value {
+	ans := make([]*Value, size)
+	for i := range ans {
+		ans[i] = MakeValue(0.0)
+	}
+	return ans
+}
+
+// Returns new leaf values with the same data as values.
+func detach(values []*Value) []*Value {
+	ans := make([]*Value, len(values))
+	for i, value := range values {
+		ans[i] = value.Detach()
+	}
+	return ans
+}
+
+// Returns the concatenation of values.
+func concat(values ...[]*Value) []*Value {
+	ans := []*Value{}
+	for _, v := range values {
+		ans = append(ans, v...)
+	}
+	return ans
+}
+
+// Vanilla recurrent cell: h' = tanh(W [x, h] + b). The output is h'.
+type RNNCell struct {
+	hiddenSize int
+	layer      *Layer
+}
+
+// Makes a vanilla recurrent cell with a hidden state of a given size.
+func MakeRNNCell(inputSize, hiddenSize int, rng *rand.Rand) *RNNCell {
+	return &RNNCell{
+		hiddenSize: hiddenSize,
+		layer:      MakeLayer(inputSize+hiddenSize, MakeLayerParam(hiddenSize, Tanh), rng),
+	}
+}
+
+// Computes the next hidden state which is also the output.
+func (c *RNNCell) Step(input, state []*Value) ([]*Value, []*Value) {
+	h := c.layer.Fit(concat(input, state))
+	return h, h
+}
+
+// Returns a hidden state of zeros.
+func (c *RNNCell) InitialState() []*Value {
+	return zeros(c.hiddenSize)
+}
+
+// Returns the parameters of the cell.
+func (c *RNNCell) Parameters() []*Value {
+	return c.layer.Parameters()
+}
+
+// Returns the neurons of the cell.
+func (c *RNNCell) Neurons() []*Neuron {
+	return c.layer.neurons
+}
+
+// Returns a copy of the cell with new parameters of the same values.
+func (c *RNNCell) Clone() Cell {
+	return &RNNCell{
+		hiddenSize: c.hiddenSize,
+		layer:      cloneLayer(c.layer),
+	}
+}
+
+// Long short-term memory cell. The state is the concatenation of the hidden
+// state h and the cell state c:
+//
+//	i = sigmoid(W_i [x, h] + b_i), f = sigmoid(W_f [x, h] + b_f)
+//	g = tanh(W_g [x, h] + b_g), o = sigmoid(W_o [x, h] + b_o)
+//	c' = f*c + i*g, h' = o*tanh(c')
+//
+// The output is h'.
+type LSTMCell struct {
+	hiddenSize          int
+	input, forget, cell *Layer
+	output              *Layer
+}
+
+// Makes an LSTM cell with hidden and cell states of a given size.
+func MakeLSTMCell(inputSize, hiddenSize int, rng *rand.Rand) *LSTMCell {
+	gate := func(activation func(*Value) *Value) *Layer {
+		return MakeLayer(inputSize+hiddenSize, MakeLayerParam(hiddenSize, activation), rng)
+	}
+	return &LSTMCell{
+		hiddenSize: hiddenSize,
+		input:      gate(Sigmoid),
+		forget:     gate(Sigmoid),
+		cell:       gate(Tanh),
+		output:     gate(Sigmoid),
+	}
+}
+
+// Computes the next hidden and cell states. The output is the hidden state.
+func (c *LSTMCell) Step(input, state []*Value) ([]*Value, []*Value) {
+	h, cell := state[:c.hiddenSize], state[c.hiddenSize:]
+	x := concat(input, h)
+	i, f, g, o := c.input.Fit(x), c.forget.Fit(x), c.cell.Fit(x), c.output.Fit(x)
+	nextH := make([]*Value, c.hiddenSize)
+	nextCell := make([]*Value, c.hiddenSize)
+	for j := range nextH {
+		nextCell[j] = f[j].Mul(cell[j]).Add(i[j].Mul(g[j]))
+		nextH[j] = o[j].Mul(Tanh(nextCell[j]))
+	}
+	return nextH, concat(nextH, nextCell)
+}
+
+// Returns hidden and cell states of zeros.
+func (c *LSTMCell) InitialState() []*Value {
+	return zeros(2 * c.hiddenSize)
+}
+
+// Returns the parameters of the input, forget, cell and output gates.
+func (c *LSTMCell) Parameters() []*Value {
+	return concat(c.input.Parameters(), c.forget.Parameters(), c.cell.Parameters(), c.output.Parameters())
+}
+
+// Returns the neurons of the gates.
+func (c *LSTMCell) Neurons() []*Neuron {
+	return neurons([]Module{c.input, c.forget, c.cell, c.output})
+}
+
+// Returns a copy of the cell with new parameters of the same values.
+func (c *LSTMCell) Clone() Cell {
+	return &LSTMCell{
+		hiddenSize: c.hiddenSize,
+		input:      cloneLayer(c.input),
+		forget:     cloneLayer(c.forget),
+		cell:       cloneLayer(c.cell),
+		output:     cloneLayer(c.output),
+	}
+}
+
+// Gated recurrent unit cell:
+//
+//	z = sigmoid(W_z [x, h] + b_z), r = sigmoid(W_r [x, h] + b_r)
+//	n = tanh(W_n [x, r*h] + b_n), h' = (1-z)*n + z*h
+//
+// The output is h'.
+type GRUCell struct {
+	hiddenSize          int
+	update, reset, cell *Layer
+}
+
+// Makes a GRU cell with a hidden state of a given size.
+func MakeGRUCell(inputSize, hiddenSize int, rng *rand.Rand) *GRUCell {
+	gate := func(activation func(*Value) *Value) *Layer {
+		return MakeLayer(inputSize+hiddenSize, MakeLayerParam(hiddenSize, activation), rng)
+	}
+	return &GRUCell{
+		hiddenSize: hiddenSize,
+		update:     gate(Sigmoid),
+		reset:      gate(Sigmoid),
+		cell:       gate(Tanh),
+	}
+}
+
+// Computes the next hidden state which is also the output.
+func (c *GRUCell) Step(input, state []*Value) ([]*Value, []*Value) {
+	x := concat(input, state)
+	z, r := c.update.Fit(x), c.reset.Fit(x)
+	resetH := make([]*Value, c.hiddenSize)
+	for j := range resetH {
+		resetH[j] = r[j].Mul(state[j])
+	}
+	n := c.cell.Fit(concat(input, resetH))
+	h := make([]*Value, c.hiddenSize)
+	one := MakeValue(1.0)
+	for j := range h {
+		h[j] = one.Sub(z[j]).Mul(n[j]).Add(z[j].Mul(state[j]))
+	}
+	return h, h
+}
+
+// Returns a hidden state of zeros.
+func (c *GRUCell) InitialState() []*Value {
+	return zeros(c.hiddenSize)
+}
+
+// Returns the parameters of the update, reset and cell gates.
+func (c *GRUCell) Parameters() []*Value {
+	return concat(c.update.Parameters(), c.reset.Parameters(), c.cell.Parameters())
+}
+
+// Returns the neurons of the gates.
+func (c *GRUCell) Neurons() []*Neuron {
+	return neurons([]Module{c.update, c.reset, c.cell})
+}
+
+// Returns a copy of the cell with new parameters of the same values.
+func (c *GRUCell) Clone() Cell {
+	return &GRUCell{
+		hiddenSize: c.hiddenSize,
+		update:     cloneLayer(c.update),
+		reset:      cloneLayer(c.reset),
+		cell:       cloneLayer(c.cell),
+	}
+}
+
+// Recurrent module unrolling a cell over a sequence given as a flat input of
+// T steps of inputSize values each, so sequences of any length can be fed.
+// It outputs the output of the last step, or the outputs of all steps
+// concatenated if returnSequences is true.
+type RNN struct {
+	cell            Cell
+	inputSize       int
+	returnSequences bool
+}
+
+// Makes a recurrent module from a cell.
+func MakeRNN(cell Cell, inputSize int, returnSequences bool) *RNN {
+	return &RNN{
+		cell:            cell,
+		inputSize:       inputSize,
+		returnSequences: returnSequences,
+	}
+}
+
+// Unrolls the cell over the input sequence from the initial state.
+func (r *RNN) Forward(input []*Value) []*Value {
+	outputs, _ := Unroll(r.cell, split(input, r.inputSize), nil)
+	if r.returnSequences {
+		return concat(outputs...)
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+	return outputs[len(outputs)-1]
+}
+
+// Returns the parameters of the cell.
+func (r *RNN) Parameters() []*Value {
+	return r.cell.Parameters()
+}
+
+// Returns the neurons of the cell if it implements NeuronModule.
+func (r *RNN) Neurons() []*Neuron {
+	if c, ok := r.cell.(NeuronModule); ok {
+		return c.Neurons()
+	}
+	return nil
+}
+
+// Returns a copy of the module with a copy of its cell. It panics if the cell
+// has parameters but does not implement CloneableCell.
+func (r *RNN) Clone() Module {
+	cell := r.cell
+	if c, ok := cell.(CloneableCell); ok {
+		cell = c.Clone()
+	} else if len(cell.Parameters()) > 0 {
+		panic(fmt.Sprintf("cannot clone cell of type %T", cell))
+	}
+	return MakeRNN(cell, r.inputSize, r.returnSequences)
+}
+
+// Returns the training parameters of a chunk of a given number of steps of
+// sequence i. The sample weight of a sequence is the weight of each of its
+// steps.
+func chunkParam(trainingParam TrainingParam, i, steps int) TrainingParam {
+	if trainingParam.SampleWeights == nil {
+		return trainingParam
+	}
+	weights := make([]float64, steps)
+	for j := range weights {
+		weights[j] = trainingParam.SampleWeights[i]
+	}
+	trainingParam.SampleWeights = weights
+	return trainingParam
+}
+
+// Trains a network whose first module is an RNN on sequences with a label
+// per step, using truncated backpropagation through time. The remaining
+// modules are applied on the output of each step. Each sequence is split into
+// chunks of at most truncation steps; the state is carried between chunks but
+// gradients do not flow past the start of a chunk, and the parameters are
+// updated after each chunk. Sample weights, if given, are the weights of the
+// sequences. Returns the mean loss of the chunks in each epoch and the
+// learning rate of each step.
+//
+// The RNN must return sequences, truncation must be positive and sequences
+// must not be empty, with a label per step. HessianFree, AccumulationSteps
+// and MixupAlpha are not supported.
+func (n *NeuralNetwork) TrainSequences(sequences, labels [][][]*Value, truncation int, trainingParam TrainingParam) ([]float64, []float64) {
+	rnn, ok := n.modules[0].(*RNN)
+	if !ok {
+		panic("TrainSequences expects an RNN as the first module")
+	}
+	if !rnn.returnSequences {
+		panic("TrainSequences expects an RNN returning sequences")
+	}
+	if truncation < 1 {
+		panic(fmt.Sprintf("expected a positive truncation, got %d", truncation))
+	}
+	if len(sequences) == 0 || len(labels) != len(sequences) {
+		panic(fmt.Sprintf("expected sequences with labels, got %d sequences and %d labels", len(sequences), len(labels)))
+	}
+	for i, sequence := range sequences {
+		if len(sequence) == 0 {
+			panic(fmt.Sprintf("sequence %d is empty", i))
+		}
+		if len(labels[i]) != len(sequence) {
+			panic(fmt.Sprintf("expected %d labels of sequence %d, got %d", len(sequence), i, len(labels[i])))
+		}
+	}
+	if trainingParam.HessianFree != nil || trainingParam.AccumulationSteps > 1 || trainingParam.MixupAlpha > 0.0 {
+		panic("TrainSequences does not support HessianFree, AccumulationSteps and MixupAlpha")
+	}
+	n.SetTraining(true)
+	defer n.SetTraining(false)
+	// Classes are balanced over the steps of all sequences.
+	allLabels := [][]*Value{}
+	for _, sequenceLabels := range labels {
+		allLabels = append(allLabels, sequenceLabels...)
+	}
+	trainingParam = balanceClasses(allLabels, trainingParam)
+
+	losses := make([]float64, trainingParam.Epochs)
+	learningRates := []float64{}
+	for epoch := range losses {
+		chunks := 0
+		for i, sequence := range sequences {
+			var state []*Value
+			for start := 0; start < len(sequence); start += truncation {
+				end := start + truncation
+				if end > len(sequence) {
+					end = len(sequence)
+				}
+				var outputs [][]*Value
+				outputs, state = Unroll(rnn.cell, sequence[start:end], state)
+				scores := forwardBatch(n.modules[1:], outputs)
+				loss := n.Loss(labels[i][start:end], scores, chunkParam(trainingParam, i, end-start))
+				losses[epoch] += loss.GetData()
+				chunks++
+
+				learningRate := trainingParam.learningRateAt(len(learningRates))
+				learningRates = append(learningRates, learningRate)
+				n.ResetGrad()
+				loss.BackPropagate()
+				n.step(trainingParam, learningRate)
+
+				state = detach(state)
+			}
+		}
+		losses[epoch] /= float64(chunks)
+		trainingParam.observe(losses[epoch])
+	}
+	return losses, learningRates
+}
@@ -0,0 +1,129 @@
+package nn
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCellGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	cells := map[string]Cell{
+		"RNN":  MakeRNNCell(2, 3, rng),
+		"LSTM": MakeLSTMCell(2, 3, rng),
+		"GRU":  MakeGRUCell(2, 3, rng),
+	}
+	for name, cell := range cells {
+		sequence := [][]*Value{makeInput(2), makeInput(2), makeInput(2)}
+		sequence[1][0].SetData(0.7)
+		values := cell.Parameters()
+		for _, input := range sequence {
+			values = append(values, input...)
+		}
+		outputs, state := Unroll(cell, sequence, nil)
+		assert.Equal(t, 3, len(outputs), "%s: expected %d, got %d", name, 3, len(outputs))
+		assert.Equal(t, len(cell.InitialState()), len(state), "%s: expected %d, got %d", name, len(cell.InitialState()), len(state))
+		for _, output := range outputs {
+			assert.Equal(t, 3, len(output), "%s: expected %d, got %d", name, 3, len(output))
+		}
+		checkGradient(t, values, func() *Value {
+			outputs, state := Unroll(cell, sequence, nil)
+			return weightedSum(append(outputs, state)...)
+		})
+	}
+}
+
+func TestRNN(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	cell := MakeGRUCell(2, 3, rng)
+	rnn := MakeRNN(cell, 2, false)
+	short, long := makeInput(2*2), makeInput(2*5)
+	assert.Equal(t, 3, len(rnn.Forward(short)), "expected %d, got %d", 3, len(rnn.Forward(short)))
+	output := rnn.Forward(long)
+	assert.Equal(t, 3, len(output), "expected %d, got %d", 3, len(output))
+
+	sequence := [][]*Value{long[0:2], long[2:4], long[4:6], long[6:8], long[8:10]}
+	outputs, state := Unroll(cell, sequence, nil)
+	for j := range state {
+		assert.Equalf(t, state[j].GetData(), output[j].GetData(), "expected %f, got %f", state[j].GetData(), output[j].GetData())
+	}
+	// The state is carried when unrolling a continuation.
+	first, middle := Unroll(cell, sequence[:2], nil)
+	second, _ := Unroll(cell, sequence[2:], middle)
+	assert.Equal(t, len(outputs), len(first)+len(second), "expected %d, got %d", len(outputs), len(first)+len(second))
+	assert.Equalf(t, outputs[4][0].GetData(), second[2][0].GetData(), "expected %f, got %f", outputs[4][0].GetData(), second[2][0].GetData())
+
+	rnn = MakeRNN(cell, 2, true)
+	assert.Equal(t, 5*3, len(rnn.Forward(long)), "expected %d, got %d", 5*3, len(rnn.Forward(long)))
+	assert.Equal(t, cell.Parameters(), rnn.Parameters())
+	assert.Panics(t, func() { rnn.Forward(long[1:]) })
+}
+
+func TestTrainSequences(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+
+	// The label of each step is the input of the previous step.
+	r := rand.New(rand.NewSource(1))
+	sequences := make([][][]*Value, 4)
+	labels := make([][][]*Value, 4)
+	for i := range sequences {
+		previous := 0.0
+		for step := 0; step < 12; step++ {
+			x := float64(r.Intn(2))
+			sequences[i] = append(sequences[i], []*Value{MakeValue(x)})
+			labels[i] = append(labels[i], []*Value{MakeValue(previous)})
+			previous = x
+		}
+	}
+
+	model := MakeModel(MakeRNN(MakeRNNCell(1, 4, rng), 1, true), MakeLayer(4, MakeLayerParam(1, Sigmoid), rng))
+	losses, learningRates := model.TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 30, LearningRate: 0.5})
+	assert.Equal(t, 30, len(losses), "expected %d, got %d", 30, len(losses))
+	// Each epoch has a step per chunk, and sequences have 3 chunks.
+	assert.Equal(t, 30*4*3, len(learningRates), "expected %d, got %d", 30*4*3, len(learningRates))
+	assert.Less(t, losses[len(losses)-1], 0.5*losses[0], "expected loss to decrease from %f, got %f", losses[0], losses[len(losses)-1])
+
+	// Classes are balanced over all sequences rather than each chunk.
+	clone := model.Clone()
+	model.TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 1, LearningRate: 0.5, ClassificationThreshold: 0.5, BalanceClasses: true})
+	allLabels := [][]*Value{}
+	for _, sequenceLabels := range labels {
+		allLabels = append(allLabels, sequenceLabels...)
+	}
+	classWeights := BalancedClassWeights(allLabels, TrainingParam{ClassificationThreshold: 0.5})
+	clone.TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 1, LearningRate: 0.5, ClassificationThreshold: 0.5, ClassWeights: classWeights})
+	assert.Equal(t, clone.GetWeights(), model.GetWeights())
+
+	assert.Panics(t, func() {
+		MakeModel(MakeLayer(1, MakeLayerParam(1, Sigmoid), rng)).TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 1})
+	})
+	// Invalid truncations, empty input and unsupported options.
+	for name, train := range map[string]func(){
+		"zero truncation":     func() { model.TrainSequences(sequences, labels, 0, TrainingParam{Epochs: 1}) },
+		"negative truncation": func() { model.TrainSequences(sequences, labels, -1, TrainingParam{Epochs: 1}) },
+		"no sequences":        func() { model.TrainSequences(nil, nil, 4, TrainingParam{Epochs: 1}) },
+		"empty sequence":      func() { model.TrainSequences([][][]*Value{{}}, [][][]*Value{{}}, 4, TrainingParam{Epochs: 1}) },
+		"HessianFree": func() {
+			model.TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 1, HessianFree: MakeHessianFree()})
+		},
+		"AccumulationSteps": func() {
+			model.TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 1, AccumulationSteps: 2})
+		},
+		"MixupAlpha": func() {
+			model.TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 1, MixupAlpha: 0.2})
+		},
+		"missing step labels": func() {
+			short := append([][][]*Value{labels[0][1:]}, labels[1:]...)
+			model.TrainSequences(sequences, short, 4, TrainingParam{Epochs: 1})
+		},
+		"last output": func() {
+			last := MakeModel(MakeRNN(MakeRNNCell(1, 4, rng), 1, false), MakeLayer(4, MakeLayerParam(1, Sigmoid), rng))
+			last.TrainSequences(sequences, labels, 4, TrainingParam{Epochs: 1})
+		},
+	} {
+		assert.Panics(t, train, name)
+	}
+}
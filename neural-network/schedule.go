@@ -0,0 +1,165 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+)
+
+// A scheduler sets the learning rate of each training step, starting at step
+// 0, given the initial learning rate TrainingParam.LearningRate.
+type Scheduler interface {
+	LearningRate(step int, learningRate float64) float64
+}
+
+// Adapts a function to the Scheduler interface.
+type schedulerFunc func(step int, learningRate float64) float64
+
+func (f schedulerFunc) LearningRate(step int, learningRate float64) float64 {
+	return f(step, learningRate)
+}
+
+// Schedulers driven by the loss, e.g. ReduceOnPlateau, implement LossObserver
+// and are given the loss after each epoch. Train passes the loss of the
+// validation records if given and the training loss otherwise.
+type LossObserver interface {
+	Observe(loss float64)
+}
+
+// Returns the learning rate of a step given by the scheduler, if any.
+func (p TrainingParam) learningRateAt(step int) float64 {
+	if p.Scheduler == nil {
+		return p.LearningRate
+	}
+	return p.Scheduler.LearningRate(step, p.LearningRate)
+}
+
+// Passes the loss of an epoch to the scheduler if it implements
+// LossObserver.
+func (p TrainingParam) observe(loss float64) {
+	if observer, ok := p.Scheduler.(LossObserver); ok {
+		observer.Observe(loss)
+	}
+}
+
+// Multiplies the learning rate by gamma every stepSize steps.
+func StepDecay(stepSize int, gamma float64) Scheduler {
+	if stepSize < 1 {
+		panic(fmt.Sprintf("expected a positive step size, got %d", stepSize))
+	}
+	return schedulerFunc(func(step int, learningRate float64) float64 {
+		return learningRate * math.Pow(gamma, float64(step/stepSize))
+	})
+}
+
+// Multiplies the learning rate by gamma every step.
+func ExponentialDecay(gamma float64) Scheduler {
+	return schedulerFunc(func(step int, learningRate float64) float64 {
+		return learningRate * math.Pow(gamma, float64(step))
+	})
+}
+
+// Cosine annealing with warm restarts: the learning rate decreases from its
+// initial value to minLearningRate along a half cosine over a period, and
+// then restarts. The first period has the given number of steps, and each
+// period is multiplier times longer than the previous one.
+func CosineAnnealing(period, multiplier int, minLearningRate float64) Scheduler {
+	if period < 1 || multiplier < 1 {
+		panic(fmt.Sprintf("expected a positive period and multiplier, got %d and %d", period, multiplier))
+	}
+	return schedulerFunc(func(step int, learningRate float64) float64 {
+		length := period
+		for step >= length {
+			step -= length
+			length *= multiplier
+		}
+		cos := math.Cos(math.Pi * float64(step) / float64(length))
+		return minLearningRate + 0.5*(learningRate-minLearningRate)*(1+cos)
+	})
+}
+
+// Increases the learning rate linearly from learningRate/steps to
+// learningRate over the first steps, and then follows a scheduler starting
+// from its step 0, or keeps the learning rate constant if it is nil.
+func LinearWarmup(steps int, then Scheduler) Scheduler {
+	return schedulerFunc(func(step int, learningRate float64) float64 {
+		if step < steps {
+			return learningRate * float64(step+1) / float64(steps)
+		}
+		if then == nil {
+			return learningRate
+		}
+		return then.LearningRate(step-steps, learningRate)
+	})
+}
+
+// One-cycle policy over a number of steps with the learning rate as its
+// maximum: the learning rate increases from learningRate/25 to learningRate
+// along a half cosine over the first 30% of the steps, and then decreases to
+// learningRate/1e4 along a half cosine. A single step uses learningRate.
+func OneCycle(steps int) Scheduler {
+	if steps < 1 {
+		panic(fmt.Sprintf("expected a positive number of steps, got %d", steps))
+	}
+	warmup := int(0.3 * float64(steps))
+	anneal := func(from, to float64, step, steps int) float64 {
+		if steps <= 0 {
+			return to
+		}
+		cos := math.Cos(math.Pi * math.Min(float64(step)/float64(steps), 1.0))
+		return to + 0.5*(from-to)*(1+cos)
+	}
+	return schedulerFunc(func(step int, learningRate float64) float64 {
+		if steps == 1 {
+			return learningRate
+		}
+		if step < warmup {
+			return anneal(learningRate/25, learningRate, step, warmup)
+		}
+		return anneal(learningRate, learningRate/1e4, step-warmup, steps-warmup-1)
+	})
+}
+
+// Reduces the learning rate by Factor when the observed loss has not
+// decreased by more than Threshold for more than Patience epochs, down to
+// MinLearningRate. It keeps the state of training, so a ReduceOnPlateau
+// object should not be reused between trainings. A struct literal setting the
+// exported fields is ready to use.
+type ReduceOnPlateau struct {
+	Factor          float64
+	Patience        int
+	Threshold       float64
+	MinLearningRate float64
+	// Best observed loss, if observed is set.
+	best     float64
+	observed bool
+	// Number of epochs since the best loss.
+	wait int
+	// Number of reductions so far.
+	reductions int
+}
+
+// Makes a ReduceOnPlateau scheduler given the factor and patience.
+func MakeReduceOnPlateau(factor float64, patience int) *ReduceOnPlateau {
+	return &ReduceOnPlateau{
+		Factor:    factor,
+		Patience:  patience,
+		Threshold: 1e-4,
+	}
+}
+
+func (s *ReduceOnPlateau) LearningRate(step int, learningRate float64) float64 {
+	scale := math.Pow(s.Factor, float64(s.reductions))
+	return math.Max(learningRate*scale, s.MinLearningRate)
+}
+
+func (s *ReduceOnPlateau) Observe(loss float64) {
+	if !s.observed || loss < s.best-s.Threshold {
+		s.best, s.wait, s.observed = loss, 0, true
+		return
+	}
+	s.wait++
+	if s.wait > s.Patience {
+		s.reductions++
+		s.wait = 0
+	}
+}
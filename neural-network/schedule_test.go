@@ -0,0 +1,119 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulers(t *testing.T) {
+	cos := func(x float64) float64 { return 0.5 * (1 + math.Cos(math.Pi*x)) }
+	tests := map[string]struct {
+		scheduler Scheduler
+		steps     []int
+		expected  []float64
+	}{
+		"StepDecay":        {StepDecay(3, 0.5), []int{0, 2, 3, 7}, []float64{1.0, 1.0, 0.5, 0.25}},
+		"ExponentialDecay": {ExponentialDecay(0.9), []int{0, 1, 3}, []float64{1.0, 0.9, 0.729}},
+		// Periods of 4 and 8 steps.
+		"CosineAnnealing": {
+			CosineAnnealing(4, 2, 0.1),
+			[]int{0, 2, 3, 4, 8, 12},
+			[]float64{1.0, 0.55, 0.1 + 0.9*cos(0.75), 1.0, 0.55, 1.0},
+		},
+		"LinearWarmup": {LinearWarmup(4, nil), []int{0, 1, 3, 10}, []float64{0.25, 0.5, 1.0, 1.0}},
+		"LinearWarmupDecay": {
+			LinearWarmup(2, ExponentialDecay(0.5)),
+			[]int{0, 1, 2, 3, 4},
+			[]float64{0.5, 1.0, 1.0, 0.5, 0.25},
+		},
+		// Warms up over 3 steps and anneals over 6.
+		"OneCycle": {
+			OneCycle(10),
+			[]int{0, 3, 6, 9},
+			[]float64{0.04, 1.0, 1e-4 + (1-1e-4)*cos(0.5), 1e-4},
+		},
+	}
+	for name, test := range tests {
+		for i, step := range test.steps {
+			actual := test.scheduler.LearningRate(step, 1.0)
+			assert.InDelta(t, test.expected[i], actual, 1e-12, "%s, step %d: expected %f, got %f", name, step, test.expected[i], actual)
+		}
+	}
+	// The one-cycle learning rate increases and then decreases.
+	oneCycle := OneCycle(20)
+	for step := 1; step < 20; step++ {
+		before, after := oneCycle.LearningRate(step-1, 1.0), oneCycle.LearningRate(step, 1.0)
+		if step <= 6 {
+			assert.Greater(t, after, before, "step %d", step)
+		} else {
+			assert.Less(t, after, before, "step %d", step)
+		}
+	}
+	assert.Panics(t, func() { CosineAnnealing(4, 0, 0.0) })
+	assert.Panics(t, func() { StepDecay(0, 0.5) })
+	// A single step of one-cycle uses the maximum learning rate.
+	assert.Equal(t, 1.0, OneCycle(1).LearningRate(0, 1.0))
+	assert.Panics(t, func() { OneCycle(0) })
+}
+
+func TestReduceOnPlateau(t *testing.T) {
+	scheduler := MakeReduceOnPlateau(0.5, 1)
+	scheduler.MinLearningRate = 0.3
+	expected := []float64{1.0, 1.0, 1.0, 1.0, 0.5, 0.5, 0.5, 0.3}
+	for i, loss := range []float64{1.0, 0.8, 0.8, 0.9, 0.7, 0.7, 0.7, 0.6} {
+		actual := scheduler.LearningRate(i, 1.0)
+		assert.Equal(t, expected[i], actual, "epoch %d: expected %f, got %f", i, expected[i], actual)
+		scheduler.Observe(loss)
+	}
+
+	// A struct literal starts at the initial learning rate.
+	literal := &ReduceOnPlateau{Factor: 0.5, Patience: 0}
+	for i, loss := range []float64{1.0, 0.5, 0.5} {
+		assert.Equal(t, 1.0, literal.LearningRate(i, 1.0), "epoch %d", i)
+		literal.Observe(loss)
+	}
+	assert.Equal(t, 0.5, literal.LearningRate(3, 1.0))
+}
+
+func TestTrainSchedule(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	inputs := makeRecords([][]float64{{1.0, 2.0}, {-1.0, 0.5}, {0.5, -1.0}})
+	labels := makeRecords([][]float64{{1.0}, {0.0}, {1.0}})
+
+	// The learning rate of each step is returned.
+	model := MakeNeuralNetwork(2, []LayerParam{MakeLayerParam(1, Sigmoid)}, rng)
+	scheduler := LinearWarmup(2, StepDecay(2, 0.1))
+	trainingParam := TrainingParam{Epochs: 5, LearningRate: 0.5, Scheduler: scheduler}
+	_, _, learningRates := model.Train(inputs, labels, trainingParam)
+	expected := []float64{0.25, 0.5, 0.5, 0.5, 0.05}
+	assert.InDeltaSlice(t, expected, learningRates, 1e-12, "expected %v, got %v", expected, learningRates)
+
+	// Only the first step of StepDecay(1, 0) changes the model.
+	clone := model.Clone()
+	trainingParam = TrainingParam{Epochs: 3, LearningRate: 1.0, Scheduler: StepDecay(1, 0.0)}
+	_, _, learningRates = model.Train(inputs, labels, trainingParam)
+	assert.Equal(t, []float64{1.0, 0.0, 0.0}, learningRates)
+	clone.Train(inputs, labels, TrainingParam{Epochs: 1, LearningRate: 1.0})
+	assert.Equal(t, clone.GetWeights(), model.GetWeights())
+
+	// The validation loss of a frozen model does not decrease, so the
+	// learning rate is reduced after every patience+1 epochs.
+	layer := MakeLayer(2, MakeLayerParam(1, Sigmoid), rng)
+	layer.SetTrainable(false)
+	model = MakeModel(layer)
+	trainingParam = TrainingParam{
+		Epochs:           7,
+		LearningRate:     1.0,
+		Scheduler:        MakeReduceOnPlateau(0.5, 2),
+		ValidationInputs: inputs[:2],
+		ValidationLabels: labels[:2],
+		SampleWeights:    []float64{1.0, 2.0, 3.0},
+	}
+	_, _, learningRates = model.Train(inputs, labels, trainingParam)
+	assert.Equal(t, []float64{1.0, 1.0, 1.0, 1.0, 0.5, 0.5, 0.5}, learningRates)
+	expectedLoss := model.Loss(labels[:2], model.Predict(inputs[:2]), TrainingParam{}).GetData()
+	assert.Equal(t, expectedLoss, model.validationLoss(0.0, trainingParam))
+}
@@ -0,0 +1,144 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Mean, standard deviation, minimum and maximum of values.
+type statistics struct {
+	mean, std, min, max float64
+}
+
+func makeStatistics(values []float64) statistics {
+	ans := statistics{min: math.Inf(1), max: math.Inf(-1)}
+	for _, x := range values {
+		ans.mean += x
+		ans.min = math.Min(ans.min, x)
+		ans.max = math.Max(ans.max, x)
+	}
+	ans.mean /= float64(len(values))
+	for _, x := range values {
+		ans.std += (x - ans.mean) * (x - ans.mean)
+	}
+	ans.std = math.Sqrt(ans.std / float64(len(values)))
+	return ans
+}
+
+func (s statistics) String() string {
+	return fmt.Sprintf("%.3g, %.3g, %.3g, %.3g", s.mean, s.std, s.min, s.max)
+}
+
+// Returns the fraction of units which output 0 for all records.
+func deadFraction(outputs [][]*Value) float64 {
+	dead := 0
+	for j := range outputs[0] {
+		alive := false
+		for _, output := range outputs {
+			if output[j].data != 0.0 {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			dead++
+		}
+	}
+	return float64(dead) / float64(len(outputs[0]))
+}
+
+// A module of the summary and its index, e.g. 1.0 for the first module of a
+// Sequential at index 1.
+type summaryRow struct {
+	index  string
+	module Module
+}
+
+// Returns the modules in order, replacing each Sequential by its modules.
+func summaryRows(modules []Module, prefix string) []summaryRow {
+	rows := []summaryRow{}
+	for i, module := range modules {
+		index := prefix + strconv.Itoa(i)
+		if s, ok := module.(*Sequential); ok {
+			rows = append(rows, summaryRows(s.modules, index+".")...)
+			continue
+		}
+		rows = append(rows, summaryRow{index, module})
+	}
+	return rows
+}
+
+// Returns a table of the modules of the network with their input and output
+// sizes, activations, numbers of parameters and statistics (mean, std, min,
+// max) of the current parameters and their gradients, followed by the total
+// number of parameters. Modules of a Sequential are listed separately.
+//
+// If records are given, they are fed through the network in inference mode
+// to measure the sizes of all modules, and the fraction of dead units of ReLU
+// layers, i.e. units which output 0 for all records, is reported too.
+func (n *NeuralNetwork) Summary(inputs ...[]*Value) string {
+	rows := summaryRows(n.modules, "")
+	var outputs [][][]*Value
+	if len(inputs) > 0 {
+		training := n.Training()
+		n.SetTraining(false)
+		defer n.SetTraining(training)
+
+		outputs = make([][][]*Value, len(rows)+1)
+		outputs[0] = inputs
+		for i, row := range rows {
+			outputs[i+1] = forwardBatch([]Module{row.module}, outputs[i])
+		}
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tModule\tInput\tOutput\tActivation\tParameters\tTrainable\tWeights (mean, std, min, max)\tGradients (mean, std, min, max)\tDead ReLUs")
+	total, trainableTotal := 0, 0
+	for i, row := range rows {
+		module := row.module
+		input, output := "-", "-"
+		if sized, ok := module.(interface {
+			InputSize() int
+			OutputSize() int
+		}); ok {
+			input, output = strconv.Itoa(sized.InputSize()), strconv.Itoa(sized.OutputSize())
+		}
+		if outputs != nil {
+			input, output = strconv.Itoa(len(outputs[i][0])), strconv.Itoa(len(outputs[i+1][0]))
+		}
+
+		activation, dead := "-", "-"
+		if layer, ok := module.(*Layer); ok {
+			if layer.name != "" {
+				activation = layer.name
+			}
+			if outputs != nil && layer.name == "ReLU" && len(layer.neurons) > 0 {
+				dead = fmt.Sprintf("%.2f", deadFraction(outputs[i+1]))
+			}
+		}
+
+		params := module.Parameters()
+		weights, grads := "-", "-"
+		if len(params) > 0 {
+			data, grad := make([]float64, len(params)), make([]float64, len(params))
+			for j, param := range params {
+				data[j], grad[j] = param.data, param.grad
+			}
+			weights, grads = makeStatistics(data).String(), makeStatistics(grad).String()
+		}
+		total += len(params)
+		trainableTotal += len(trainable(params))
+
+		name := strings.TrimPrefix(fmt.Sprintf("%T", module), "*")
+		name = name[strings.LastIndex(name, ".")+1:]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			row.index, name, input, output, activation, len(params), len(trainable(params)), weights, grads, dead)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "Total parameters: %d, trainable: %d\n", total, trainableTotal)
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package nn
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	// Negative weights on positive inputs kill 3 of the 4 units.
+	hidden := MakeLayer(2, MakeLayerParam(4, Relu).WithInitializers(Constant(-1.0), Zeros), rng)
+	hidden.neurons[0].intercept.SetData(10.0)
+	hidden.neurons[0].intercept.SetTrainable(false)
+	for _, weight := range hidden.neurons[0].weights {
+		weight.SetTrainable(false)
+	}
+	model := MakeModel(
+		hidden,
+		MakeDropout(0.5, rng),
+		MakeLayer(4, MakeVectorLayerParam(3, Softmax), rng),
+		MakeSequential(
+			MakeLayer(3, MakeLayerParam(2, Relu).WithInitializers(Constant(-1.0), Zeros), rng),
+			MakeLayer(2, MakeLayerParam(2, Tanh).WithName("Custom").WithInitializers(Constant(-1.0), Zeros), rng),
+		),
+	)
+
+	lines := strings.Split(model.Summary(), "\n")
+	assert.Equal(t, 8, len(lines), "expected %d, got %d", 8, len(lines))
+	assert.Equal(t, []string{"0", "Layer", "2", "4", "ReLU", "12", "9", "0.167,", "3,", "-1,", "10", "0,", "0,", "0,", "0", "-"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"1", "Dropout", "-", "-", "-", "0", "0", "-", "-", "-"}, strings.Fields(lines[2]))
+	assert.Equal(t, []string{"2", "Layer", "4", "3", "Softmax", "15", "15"}, strings.Fields(lines[3])[:7])
+	// Modules of a Sequential are listed on their own rows.
+	assert.Equal(t, []string{"3.0", "Layer", "3", "2", "ReLU", "8", "8"}, strings.Fields(lines[4])[:7])
+	assert.Equal(t, []string{"3.1", "Layer", "2", "2", "Custom", "6", "6"}, strings.Fields(lines[5])[:7])
+	assert.Equal(t, "Total parameters: 41, trainable: 38", lines[6])
+
+	inputs := makeRecords([][]float64{{1.0, 2.0}, {0.5, 0.1}})
+	model.SetTraining(true)
+	lines = strings.Split(model.Summary(inputs...), "\n")
+	assert.True(t, model.Training())
+	assert.Equal(t, "0.75", strings.Fields(lines[1])[15])
+	assert.Equal(t, []string{"1", "Dropout", "4", "4"}, strings.Fields(lines[2])[:4])
+	// Dead units are only reported for ReLU layers: the nested ReLU layer is
+	// dead and so are the outputs of the tanh layer, which are tanh(0) = 0.
+	assert.Equal(t, "-", strings.Fields(lines[3])[15])
+	assert.Equal(t, "1.00", strings.Fields(lines[4])[15])
+	assert.Equal(t, "-", strings.Fields(lines[5])[15])
+}
+
+func TestActivationNames(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	for _, tt := range []struct {
+		param LayerParam
+		want  string
+	}{
+		{MakeLayerParam(1, Relu), "ReLU"},
+		{MakeLayerParam(1, Tanh), "Tanh"},
+		{MakeLayerParam(1, LeakyRelu(0.1)), "LeakyReLU"},
+		{MakeLayerParam(1, Elu(0.5)), "ELU"},
+		{MakeLearnableLayerParam(1, PRelu(0.2)), "PReLU"},
+		{MakeLearnableLayerParam(1, Swish(2.0)), "Swish"},
+		{MakeVectorLayerParam(1, Softmax), "Softmax"},
+		{MakeLayerParam(1, nil), "-"},
+		{MakeLayerParam(1, func(v *Value) *Value { return v }), "-"},
+		{MakeLayerParam(1, Relu).WithName("Custom"), "Custom"},
+	} {
+		model := MakeModel(MakeLayer(1, tt.param, rng))
+		lines := strings.Split(model.Summary(), "\n")
+		assert.Equal(t, tt.want, strings.Fields(lines[1])[4])
+	}
+}
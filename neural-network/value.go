@@ -12,14 +12,29 @@ import (
 // Value object.
 // Leaf nodes represent input data with op="", len(children)=0 backward=nil.
 // Other nodes represents data resulted from an operation (op) on children.
-// backward() updates gradient of children.
+// backward() passes the gradient of a node to its children via accumulate.
+//
+// Concurrency: building a graph only reads the data of its children, so
+// several goroutines may run forward passes over shared leaves (e.g. the
+// parameters of a model) as long as nobody modifies them meanwhile.
+// BackPropagate writes grad of every node in the graph including the shared
+// leaves and is not safe for concurrent use. Use Backward instead which
+// writes gradients into a per-call buffer that can be merged afterwards.
 type Value struct {
 	data, grad float64
 	op         string
 	children   []*Value
-	backward   func()
+	backward   func(grad float64, accumulate accumulator)
+	// Frozen values get no gradient and are not updated by training.
+	frozen bool
 }
 
+// Adds grad to the gradient of a value.
+type accumulator func(value *Value, grad float64)
+
+// Gradients of values computed by a backward pass, keyed by value.
+type Gradients map[*Value]float64
+
 // Makes a new value from a float number.
 func MakeValue(data float64) *Value {
 	return &Value{
@@ -52,6 +67,18 @@ func (value *Value) ResetGrad() {
 	value.grad = 0.0
 }
 
+// Returns whether the value is trained, i.e. gets a gradient in backward
+// passes and is updated by the optimizer. Values are trainable by default.
+func (value Value) Trainable() bool {
+	return !value.frozen
+}
+
+// Freezes or unfreezes the value. Backward passes do not propagate gradients
+// to a frozen value nor through it to its children.
+func (value *Value) SetTrainable(trainable bool) {
+	value.frozen = !trainable
+}
+
 // Addition: a+b
 func (value *Value) Add(other *Value) *Value {
 	op := "+"
@@ -63,9 +90,9 @@ func (value *Value) Add(other *Value) *Value {
 		op:       op,
 		children: []*Value{value, other},
 	}
-	ans.backward = func() {
-		value.grad += ans.grad
-		other.grad += ans.grad
+	ans.backward = func(grad float64, accumulate accumulator) {
+		accumulate(value, grad)
+		accumulate(other, grad)
 	}
 	return ans
 }
@@ -81,9 +108,9 @@ func (value *Value) Mul(other *Value) *Value {
 		op:       op,
 		children: []*Value{value, other},
 	}
-	ans.backward = func() {
-		value.grad += other.data * ans.grad
-		other.grad += value.data * ans.grad
+	ans.backward = func(grad float64, accumulate accumulator) {
+		accumulate(value, other.data*grad)
+		accumulate(other, value.data*grad)
 	}
 	return ans
 }
@@ -95,8 +122,8 @@ func (value *Value) Pow(b float64) *Value {
 		op:       op,
 		children: []*Value{value},
 	}
-	ans.backward = func() {
-		value.grad += (b * math.Pow(value.data, b-1.0)) * ans.grad
+	ans.backward = func(grad float64, accumulate accumulator) {
+		accumulate(value, (b*math.Pow(value.data, b-1.0))*grad)
 	}
 	return ans
 }
@@ -112,9 +139,9 @@ func (value *Value) Sub(other *Value) *Value {
 		op:       op,
 		children: []*Value{value, other},
 	}
-	ans.backward = func() {
-		value.grad += ans.grad
-		other.grad -= ans.grad
+	ans.backward = func(grad float64, accumulate accumulator) {
+		accumulate(value, grad)
+		accumulate(other, -grad)
 	}
 	return ans
 }
@@ -130,8 +157,8 @@ func (value *Value) Log() *Value {
 		op:       "Log",
 		children: []*Value{value},
 	}
-	ans.backward = func() {
-		value.grad += (1.0 / value.data) * ans.grad
+	ans.backward = func(grad float64, accumulate accumulator) {
+		accumulate(value, (1.0/value.data)*grad)
 	}
 	return ans
 }
@@ -143,24 +170,99 @@ func (value *Value) Exp() *Value {
 		op:       "Exp",
 		children: []*Value{value},
 	}
-	ans.backward = func() {
-		value.grad += data * ans.grad
+	ans.backward = func(grad float64, accumulate accumulator) {
+		accumulate(value, data*grad)
+	}
+	return ans
+}
+
+// Returns a new leaf value with the same data, so gradients do not flow
+// through it to the graph of this value.
+func (value *Value) Detach() *Value {
+	return MakeValue(value.data)
+}
+
+// Maximum: max(a_1, ..., a_n)
+// The gradient flows to the first maximum value only.
+func Max(values ...*Value) *Value {
+	arg := values[0]
+	for _, value := range values[1:] {
+		if value.data > arg.data {
+			arg = value
+		}
+	}
+	ans := &Value{
+		data:     arg.data,
+		op:       "Max",
+		children: values,
+	}
+	ans.backward = func(grad float64, accumulate accumulator) {
+		accumulate(arg, grad)
 	}
 	return ans
 }
 
 // Implements backward propagation the topologically sorted list of nodes.
 // It's applied on the loss function value which needs to be minimized.
+// Gradients are accumulated in the grad of every node in the graph.
 func (value *Value) BackPropagate() {
 	sorted := []*Value{}
 	topoSort(value, map[*Value]bool{}, &sorted)
 
+	requires := requiresGrad(sorted)
 	value.grad = 1.0
+	accumulate := func(child *Value, grad float64) {
+		if requires[child] {
+			child.grad += grad
+		}
+	}
 	for i := len(sorted) - 1; i >= 0; i-- {
-		if sorted[i].backward != nil {
-			sorted[i].backward()
+		if sorted[i].backward != nil && requires[sorted[i]] {
+			sorted[i].backward(sorted[i].grad, accumulate)
+		}
+	}
+}
+
+// Implements backward propagation like BackPropagate, but returns the
+// gradients in a new buffer instead of writing grad of the nodes. The graph is
+// only read, so it's safe to call concurrently on graphs sharing leaves.
+func (value *Value) Backward() Gradients {
+	sorted := []*Value{}
+	topoSort(value, map[*Value]bool{}, &sorted)
+
+	requires := requiresGrad(sorted)
+	grads := Gradients{value: 1.0}
+	accumulate := func(child *Value, grad float64) {
+		if requires[child] {
+			grads[child] += grad
+		}
+	}
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if sorted[i].backward != nil && requires[sorted[i]] {
+			sorted[i].backward(grads[sorted[i]], accumulate)
+		}
+	}
+	return grads
+}
+
+// Returns the nodes of a topologically sorted graph which need a gradient:
+// trainable leaves and trainable nodes depending on them. Subgraphs only
+// depending on frozen values are skipped by backward passes.
+func requiresGrad(sorted []*Value) map[*Value]bool {
+	requires := make(map[*Value]bool, len(sorted))
+	for _, node := range sorted {
+		if node.frozen {
+			continue
+		}
+		requires[node] = len(node.children) == 0
+		for _, child := range node.children {
+			if requires[child] {
+				requires[node] = true
+				break
+			}
 		}
 	}
+	return requires
 }
 
 func topoSort(value *Value, visited map[*Value]bool, ans *[]*Value) {
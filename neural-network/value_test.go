@@ -107,3 +107,62 @@ func TestValue4(t *testing.T) {
 		assert.InDelta(t, grad, values[i].GetGrad(), 0.001, "expected %f, got %f", grad, values[i].GetGrad())
 	}
 }
+
+func TestBackward(t *testing.T) {
+	x, y := MakeValue(2.0), MakeValue(3.0)
+	z := x.Add(x.Mul(y))
+	grads := z.Backward()
+
+	tol := 1e-4
+	assert.InDelta(t, 4.0, grads[x], tol, "expected %f, got %f", 4.0, grads[x])
+	assert.InDelta(t, 2.0, grads[y], tol, "expected %f, got %f", 2.0, grads[y])
+	assert.InDelta(t, 1.0, grads[z], tol, "expected %f, got %f", 1.0, grads[z])
+
+	// The graph itself is left untouched.
+	assert.Equalf(t, 0.0, x.GetGrad(), "expected %f, got %f", 0.0, x.GetGrad())
+	assert.Equalf(t, 0.0, y.GetGrad(), "expected %f, got %f", 0.0, y.GetGrad())
+	assert.Equalf(t, 0.0, z.GetGrad(), "expected %f, got %f", 0.0, z.GetGrad())
+}
+
+func TestMax(t *testing.T) {
+	x, y, z := MakeValue(1.0), MakeValue(3.0), MakeValue(3.0)
+	m := Max(x, y, z)
+	m.Mul(MakeValue(2.0)).BackPropagate()
+
+	assert.Equalf(t, 3.0, m.GetData(), "expected %f, got %f", 3.0, m.GetData())
+	assert.Equalf(t, "Max", m.GetOp(), "expected %s, got %s", "Max", m.GetOp())
+	assert.Equalf(t, 0.0, x.GetGrad(), "expected %f, got %f", 0.0, x.GetGrad())
+	assert.Equalf(t, 2.0, y.GetGrad(), "expected %f, got %f", 2.0, y.GetGrad())
+	assert.Equalf(t, 0.0, z.GetGrad(), "expected %f, got %f", 0.0, z.GetGrad())
+}
+
+func TestDetach(t *testing.T) {
+	x := MakeValue(2.0)
+	y := x.Mul(x)
+	z := y.Detach().Mul(x)
+	z.BackPropagate()
+
+	assert.Equalf(t, 8.0, z.GetData(), "expected %f, got %f", 8.0, z.GetData())
+	assert.Equalf(t, 4.0, x.GetGrad(), "expected %f, got %f", 4.0, x.GetGrad())
+	assert.Equalf(t, 0.0, y.GetGrad(), "expected %f, got %f", 0.0, y.GetGrad())
+}
+
+func TestFrozen(t *testing.T) {
+	x, w := MakeValue(2.0), MakeValue(3.0)
+	w.SetTrainable(false)
+	assert.False(t, w.Trainable())
+	// Only depends on frozen w, so it gets no gradient.
+	h := w.Mul(w)
+	z := h.Mul(x)
+	z.BackPropagate()
+	assert.Equalf(t, 9.0, x.GetGrad(), "expected %f, got %f", 9.0, x.GetGrad())
+	assert.Equalf(t, 0.0, w.GetGrad(), "expected %f, got %f", 0.0, w.GetGrad())
+	assert.Equalf(t, 0.0, h.GetGrad(), "expected %f, got %f", 0.0, h.GetGrad())
+
+	grads := z.Backward()
+	assert.Equal(t, Gradients{z: 1.0, x: 9.0}, grads)
+
+	w.SetTrainable(true)
+	grads = z.Backward()
+	assert.Equalf(t, 12.0, grads[w], "expected %f, got %f", 12.0, grads[w])
+}